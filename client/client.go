@@ -0,0 +1,368 @@
+// Package client is a Go client for the HTTP API of a node, see node.Run for the routes.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const DefaultTimeout = 10 * time.Second
+const DefaultRetryDelay = 500 * time.Millisecond
+
+// APIError is an error reported by the node, e.g. a rejected TX.
+//
+// Calls failing with it are not retried, the node would answer the same.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// ErrMalformedResponse is returned, wrapped, when the node answers with a body that can't be decoded.
+var ErrMalformedResponse = errors.New("malformed response")
+
+// Client calls the routes of a single node, it is safe for concurrent use.
+type Client struct {
+	address    string
+	httpClient *http.Client
+	// timeout of each attempt, the context passed to the calls bounds them all
+	timeout    time.Duration
+	retries    int
+	retryDelay time.Duration
+}
+
+type Option func(c *Client)
+
+// WithTimeout bounds every attempt of a call, DefaultTimeout by default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetries retries calls failing to reach the node, calls are not retried by default.
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryDelay = delay
+	}
+}
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// New creates a client for the node listening on the given address, e.g. 127.0.0.1:8080.
+func New(tcpAddress string, opts ...Option) *Client {
+	c := &Client{
+		address:    tcpAddress,
+		httpClient: http.DefaultClient,
+		timeout:    DefaultTimeout,
+		retryDelay: DefaultRetryDelay,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+func (c *Client) Status(ctx context.Context) (StatusRes, error) {
+	res := StatusRes{}
+	err := c.get(ctx, "/node/status", nil, &res)
+
+	return res, err
+}
+
+func (c *Client) Balances(ctx context.Context) (BalancesRes, error) {
+	res := BalancesRes{}
+	err := c.get(ctx, "/balances/list", nil, &res)
+
+	return res, err
+}
+
+// Sync returns a page of the canonical chain blocks following the given one, from the first block for an empty hash.
+func (c *Client) Sync(ctx context.Context, fromBlock Hash) (SyncRes, error) {
+	res := SyncRes{}
+	err := c.get(ctx, "/node/sync", url.Values{"fromBlock": {fromBlock.Hex()}}, &res)
+
+	return res, err
+}
+
+// Headers returns a page of the canonical chain headers following the given block, from the first block for an empty hash.
+func (c *Client) Headers(ctx context.Context, fromBlock Hash, limit int) (HeadersRes, error) {
+	query := url.Values{
+		"fromBlock": {fromBlock.Hex()},
+		"limit":     {strconv.Itoa(limit)},
+	}
+
+	res := HeadersRes{}
+	err := c.get(ctx, "/node/sync/headers", query, &res)
+
+	return res, err
+}
+
+// Blocks returns the blocks of the given hashes, in the same order.
+func (c *Client) Blocks(ctx context.Context, hashes []Hash) ([]Block, error) {
+	query := url.Values{}
+	for _, hash := range hashes {
+		query.Add("hash", hash.Hex())
+	}
+
+	res := SyncRes{}
+	err := c.get(ctx, "/node/sync/blocks", query, &res)
+
+	return res.Blocks, err
+}
+
+// CommonAncestor returns the fork point between the node canonical chain and the chain of the locator,
+// see the BlockLocator of the node state.
+func (c *Client) CommonAncestor(ctx context.Context, locator []Hash) (AncestorRes, error) {
+	query := url.Values{}
+	for _, hash := range locator {
+		query.Add("locator", hash.Hex())
+	}
+
+	res := AncestorRes{}
+	err := c.get(ctx, "/node/sync/ancestor", query, &res)
+
+	return res, err
+}
+
+// AddPeer asks the node to add the peer to its known peers.
+func (c *Client) AddPeer(ctx context.Context, peer PeerNode) (AddPeerRes, error) {
+	query := url.Values{
+		"ip":    {peer.IP},
+		"port":  {strconv.FormatUint(peer.Port, 10)},
+		"miner": {peer.Account.Hex()},
+	}
+
+	res := AddPeerRes{}
+	err := c.get(ctx, "/node/peer", query, &res)
+
+	return res, err
+}
+
+// Handshake introduces the calling node, the node refuses it with an APIError if they are not of the same network.
+func (c *Client) Handshake(ctx context.Context, req HandshakeReq) (HandshakeRes, error) {
+	res := HandshakeRes{}
+	err := c.post(ctx, "/node/handshake", req, &res)
+
+	return res, err
+}
+
+func (c *Client) Peers(ctx context.Context) (PeersRes, error) {
+	res := PeersRes{}
+	err := c.get(ctx, "/node/peers", nil, &res)
+
+	return res, err
+}
+
+// AddTx lets the node sign the TX with an account of its keystore.
+func (c *Client) AddTx(ctx context.Context, req TxAddReq) (TxAddRes, error) {
+	res := TxAddRes{}
+	err := c.post(ctx, "/tx/add", req, &res)
+
+	return res, err
+}
+
+// SubmitTx adds a TX signed by the caller to the node pending TXs.
+func (c *Client) SubmitTx(ctx context.Context, tx SignedTx) (TxSubmitRes, error) {
+	res := TxSubmitRes{}
+	err := c.post(ctx, "/tx/submit", TxSubmitReq{Tx: &tx}, &res)
+
+	return res, err
+}
+
+func (c *Client) Nonce(ctx context.Context, account common.Address) (NonceRes, error) {
+	res := NonceRes{}
+	err := c.get(ctx, "/account/nonce", url.Values{"account": {account.Hex()}}, &res)
+
+	return res, err
+}
+
+func (c *Client) TxProof(ctx context.Context, txHash Hash) (TxProofRes, error) {
+	res := TxProofRes{}
+	err := c.get(ctx, "/tx/proof", url.Values{"hash": {txHash.Hex()}}, &res)
+
+	return res, err
+}
+
+func (c *Client) Tx(ctx context.Context, txHash Hash) (LocatedTx, error) {
+	res := LocatedTx{}
+	err := c.get(ctx, "/tx", url.Values{"hash": {txHash.Hex()}}, &res)
+
+	return res, err
+}
+
+func (c *Client) BlockByHash(ctx context.Context, blockHash Hash) (BlockRes, error) {
+	res := BlockRes{}
+	err := c.get(ctx, "/block", url.Values{"hash": {blockHash.Hex()}}, &res)
+
+	return res, err
+}
+
+func (c *Client) BlockByNumber(ctx context.Context, number uint64) (BlockRes, error) {
+	res := BlockRes{}
+	err := c.get(ctx, "/block", url.Values{"number": {strconv.FormatUint(number, 10)}}, &res)
+
+	return res, err
+}
+
+func (c *Client) LatestHeaders(ctx context.Context, count int) (HeadersRes, error) {
+	res := HeadersRes{}
+	err := c.get(ctx, "/block/latest", url.Values{"count": {strconv.Itoa(count)}}, &res)
+
+	return res, err
+}
+
+func (c *Client) AccountTxs(ctx context.Context, account common.Address, offset int, limit int) (AccountTxsRes, error) {
+	query := url.Values{
+		"account": {account.Hex()},
+		"offset":  {strconv.Itoa(offset)},
+		"limit":   {strconv.Itoa(limit)},
+	}
+
+	res := AccountTxsRes{}
+	err := c.get(ctx, "/account/txs", query, &res)
+
+	return res, err
+}
+
+// AnnounceTXs lets the node know about pending TXs it can fetch with PendingTX.
+func (c *Client) AnnounceTXs(ctx context.Context, req TxAnnounceReq) error {
+	return c.post(ctx, "/node/tx/announce", req, &TxAnnounceRes{})
+}
+
+// PendingTX returns a TX waiting to be mined by the node.
+func (c *Client) PendingTX(ctx context.Context, txHash Hash) (SignedTx, error) {
+	res := TxRes{}
+	err := c.get(ctx, "/node/tx", url.Values{"hash": {txHash.Hex()}}, &res)
+
+	return res.Tx, err
+}
+
+func (c *Client) AnnounceBlock(ctx context.Context, req BlockAnnounceReq) error {
+	return c.post(ctx, "/node/block", req, &BlockAnnounceRes{})
+}
+
+// Work returns a template of the next block paying the given miner, see SubmitWork.
+func (c *Client) Work(ctx context.Context, miner common.Address) (WorkRes, error) {
+	res := WorkRes{}
+	err := c.get(ctx, "/mining/work", url.Values{"miner": {miner.Hex()}}, &res)
+
+	return res, err
+}
+
+// SubmitWork hands the solution of a template returned by Work to the node, which adds the block to its chain.
+func (c *Client) SubmitWork(ctx context.Context, req WorkSubmitReq) (WorkSubmitRes, error) {
+	res := WorkSubmitRes{}
+	err := c.post(ctx, "/mining/submit", req, &res)
+
+	return res, err
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, resBody interface{}) error {
+	reqUrl := fmt.Sprintf("http://%s%s", c.address, path)
+	if len(query) > 0 {
+		reqUrl += "?" + query.Encode()
+	}
+
+	return c.do(ctx, http.MethodGet, reqUrl, nil, resBody)
+}
+
+func (c *Client) post(ctx context.Context, path string, reqBody interface{}, resBody interface{}) error {
+	reqBodyJson, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("http://%s%s", c.address, path), reqBodyJson, resBody)
+}
+
+// do sends the request, again after a delay while the node can't be reached and retries are left.
+//
+// A node answering with an error or a malformed body would answer the same, the call is not retried.
+func (c *Client) do(ctx context.Context, method string, reqUrl string, reqBody []byte, resBody interface{}) error {
+	for attempt := 0; ; attempt++ {
+		err := c.doOnce(ctx, method, reqUrl, reqBody, resBody)
+
+		var apiErr *APIError
+		if err == nil || errors.As(err, &apiErr) || errors.Is(err, ErrMalformedResponse) || ctx.Err() != nil || attempt >= c.retries {
+			return err
+		}
+
+		select {
+		case <-time.After(c.retryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method string, reqUrl string, reqBody []byte, resBody interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var body io.Reader
+	if reqBody != nil {
+		body = bytes.NewReader(reqBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl, body)
+	if err != nil {
+		return err
+	}
+
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return readRes(res, resBody)
+}
+
+func readRes(r *http.Response, resBody interface{}) error {
+	resBodyJson, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read response body. %s", err.Error())
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		errRes := ErrRes{}
+		err = json.Unmarshal(resBodyJson, &errRes)
+		if err != nil || errRes.Error == "" {
+			return &APIError{r.StatusCode, fmt.Sprintf("unexpected response status %d", r.StatusCode)}
+		}
+
+		return &APIError{r.StatusCode, errRes.Error}
+	}
+
+	err = json.Unmarshal(resBodyJson, resBody)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshal response body, %w. %s", ErrMalformedResponse, err.Error())
+	}
+
+	return nil
+}
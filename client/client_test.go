@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_RetriesUnreachableNode(t *testing.T) {
+	var calls int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt loses its connection
+		if atomic.AddInt32(&calls, 1) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+
+		json.NewEncoder(w).Encode(StatusRes{Number: 7})
+	}))
+	defer server.Close()
+
+	c := New(strings.TrimPrefix(server.URL, "http://"), WithRetries(1, time.Millisecond))
+
+	status, err := c.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Number != 7 || atomic.LoadInt32(&calls) != 2 {
+		t.Fatal("the call should succeed on its second attempt")
+	}
+}
+
+func TestClient_APIErrorIsNotRetried(t *testing.T) {
+	var calls int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrRes{Error: "insufficient balance"})
+	}))
+	defer server.Close()
+
+	c := New(strings.TrimPrefix(server.URL, "http://"), WithRetries(3, time.Millisecond))
+
+	_, err := c.Balances(context.Background())
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "insufficient balance" {
+		t.Fatalf("the node error should be returned as an APIError, got %v", err)
+	}
+
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatal("errors reported by the node should not be retried")
+	}
+}
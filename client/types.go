@@ -0,0 +1,219 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rawdaGastan/learn_block_chain/internal"
+)
+
+// Types of the chain in the API, aliased for the programs outside this module, which can't import its internal package
+type (
+	Hash            = internal.Hash
+	Tx              = internal.Tx
+	SignedTx        = internal.SignedTx
+	Block           = internal.Block
+	BlockHeader     = internal.BlockHeader
+	BlockHeaderFS   = internal.BlockHeaderFS
+	LocatedTx       = internal.LocatedTx
+	MerkleProofStep = internal.MerkleProofStep
+)
+
+// Gas every TX consumes and its minimum price, see NewTx
+const TxGas = internal.TxGas
+const TxGasPriceDefault = internal.TxGasPriceDefault
+
+// NewTx returns a TX to sign, e.g. with wallet.SignTx, and submit through Client.SubmitTx.
+func NewTx(from, to common.Address, gas, gasPrice, value, nonce uint, data string) Tx {
+	return internal.NewTx(from, to, gas, gasPrice, value, nonce, data)
+}
+
+// VerifyMerkleProof tells if the proof of a TxProofRes links the TX hash to the Merkle root of the block header.
+func VerifyMerkleProof(root Hash, txHash Hash, proof []MerkleProofStep) bool {
+	return internal.VerifyMerkleProof(root, txHash, proof)
+}
+
+// PeerNode is a node of the network, reachable at its TCP address.
+type PeerNode struct {
+	IP          string `json:"ip"`
+	Port        uint64 `json:"port"`
+	IsBootstrap bool   `json:"is_bootstrap"`
+
+	// Whenever my node already established connection, sync with this Peer
+	Connected bool `json:"-"`
+	Account   common.Address
+
+	// Info negotiated in the handshake, nil until it succeeded
+	Info *PeerInfo `json:"info,omitempty"`
+}
+
+func NewPeerNode(ip string, port uint64, isBootstrap bool, connected bool, miner common.Address) PeerNode {
+	return PeerNode{IP: ip, Port: port, IsBootstrap: isBootstrap, Connected: connected, Account: miner}
+}
+
+// PeerInfo is what nodes exchange in their handshake, nodes only connect to nodes of the same network.
+type PeerInfo struct {
+	ProtocolVersion uint   `json:"protocol_version"`
+	ChainID         string `json:"chain_id"`
+	GenesisHash     Hash   `json:"genesis_hash"`
+	BestHash        Hash   `json:"best_hash"`
+	BestNumber      uint64 `json:"best_number"`
+}
+
+func (pn PeerNode) TcpAddress() string {
+	return fmt.Sprintf("%s:%d", pn.IP, pn.Port)
+}
+
+type TxAddReq struct {
+	From     string `json:"from"`
+	FromPwd  string `json:"from_pwd"`
+	To       string `json:"to"`
+	Gas      uint   `json:"gas"`
+	GasPrice uint   `json:"gasPrice"`
+	Value    uint   `json:"value"`
+	Data     string `json:"data"`
+}
+
+// TxSubmitReq carries a TX signed by the client, either as JSON or as its hex encoding.
+type TxSubmitReq struct {
+	Tx  *SignedTx `json:"tx,omitempty"`
+	Raw string    `json:"raw,omitempty"`
+}
+
+type ErrRes struct {
+	Error string `json:"error"`
+}
+
+type BalancesRes struct {
+	Hash     Hash                    `json:"block_hash"`
+	Balances map[common.Address]uint `json:"balances"`
+}
+
+type TxAddRes struct {
+	Success bool `json:"success"`
+}
+
+type TxSubmitRes struct {
+	Success bool `json:"success"`
+	Hash    Hash `json:"hash"`
+}
+
+// NonceRes holds what a wallet needs to sign the next TX of an account.
+type NonceRes struct {
+	Account common.Address `json:"account"`
+	Nonce   uint           `json:"nonce"`
+	ChainID string         `json:"chain_id"`
+}
+
+// TxProofRes proves a TX is part of a block using its header only,
+// see VerifyMerkleProof.
+type TxProofRes struct {
+	TxHash    Hash              `json:"tx_hash"`
+	BlockHash Hash              `json:"block_hash"`
+	Header    BlockHeader       `json:"header"`
+	Proof     []MerkleProofStep `json:"proof"`
+}
+
+type BlockRes struct {
+	Hash  Hash  `json:"hash"`
+	Block Block `json:"block"`
+}
+
+type HeadersRes struct {
+	Headers []BlockHeaderFS `json:"headers"`
+}
+
+// AccountTxsRes is a page of the account history, Total is the size of the whole history.
+type AccountTxsRes struct {
+	Account common.Address `json:"account"`
+	Total   int            `json:"total"`
+	Offset  int            `json:"offset"`
+	TXs     []LocatedTx    `json:"txs"`
+}
+
+type StatusRes struct {
+	Hash            Hash                `json:"block_hash"`
+	Number          uint64              `json:"block_number"`
+	TotalDifficulty uint64              `json:"total_difficulty"`
+	KnownPeers      map[string]PeerNode `json:"peers_known"`
+	PendingTXs      []SignedTx          `json:"pending_txs"`
+	Hashrate        uint64              `json:"hashrate"`
+}
+
+// AncestorRes is the latest block of a locator shared with the canonical chain of the node.
+type AncestorRes struct {
+	Hash   Hash   `json:"hash"`
+	Number uint64 `json:"number"`
+}
+
+type PeersRes struct {
+	KnownPeers map[string]PeerNode `json:"peers_known"`
+}
+
+type SyncRes struct {
+	Blocks []Block `json:"blocks"`
+}
+
+// TxAnnounceReq lets a peer know about new pending TXs it can fetch from the announcing node.
+type TxAnnounceReq struct {
+	From   PeerNode `json:"from"`
+	Hashes []Hash   `json:"hashes"`
+}
+
+type TxAnnounceRes struct {
+	Success bool `json:"success"`
+}
+
+type TxRes struct {
+	Tx SignedTx `json:"tx"`
+}
+
+// BlockAnnounceReq pushes a block freshly mined or received by the announcing node.
+type BlockAnnounceReq struct {
+	From  PeerNode `json:"from"`
+	Block Block    `json:"block"`
+}
+
+type BlockAnnounceRes struct {
+	Success bool `json:"success"`
+}
+
+// HandshakeReq introduces the calling node, see Client.Handshake.
+type HandshakeReq struct {
+	Peer PeerNode `json:"peer"`
+	Info PeerInfo `json:"info"`
+}
+
+type HandshakeRes struct {
+	Peer PeerNode `json:"peer"`
+	Info PeerInfo `json:"info"`
+}
+
+type AddPeerRes struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+}
+
+// WorkRes is a block template for an external miner, the block hash must be at most Target.
+//
+// The miner searches the header Nonce, ExtraNonce and Time, ID identifies the template TXs when submitting.
+type WorkRes struct {
+	ID     Hash        `json:"id"`
+	Header BlockHeader `json:"header"`
+	TXs    []SignedTx  `json:"txs"`
+	Target Hash        `json:"target"`
+}
+
+// WorkSubmitReq is the solution of a block template, a zero Time keeps the template time.
+type WorkSubmitReq struct {
+	ID         Hash   `json:"id"`
+	Nonce      uint32 `json:"nonce"`
+	ExtraNonce uint64 `json:"extra_nonce"`
+	Time       uint64 `json:"time"`
+}
+
+// WorkSubmitRes tells if the mined block became the tip of the canonical chain.
+type WorkSubmitRes struct {
+	Hash      Hash `json:"hash"`
+	Canonical bool `json:"canonical"`
+}
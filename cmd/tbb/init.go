@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rawdaGastan/learn_block_chain/internal"
+	"github.com/spf13/cobra"
+)
+
+func initCmd() *cobra.Command {
+	var initCmd = &cobra.Command{
+		Use:   "init",
+		Short: "Initializes a new data dir from a genesis file.",
+		Run: func(cmd *cobra.Command, args []string) {
+			genesisPath, _ := cmd.Flags().GetString(flagGenesis)
+			storage, _ := cmd.Flags().GetString(flagStorage)
+			dataDir := getDataDirFromCmd(cmd)
+
+			content, err := os.ReadFile(internal.ExpandPath(genesisPath))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			gen, err := internal.ParseGenesis(content)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			err = internal.InitDataDir(dataDir, content)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			if storage != "" {
+				err = internal.InitStorage(dataDir, storage)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+			}
+
+			fmt.Printf("Initialized data dir '%s' for chain '%s' with %d funded accounts\n", dataDir, gen.ChainID, len(gen.Balances))
+		},
+	}
+
+	addDefaultRequiredFlags(initCmd)
+
+	initCmd.Flags().String(flagGenesis, "", "path to the genesis file of the network")
+	initCmd.MarkFlagRequired(flagGenesis)
+	initCmd.Flags().String(flagStorage, "", "storage backend of the data dir: 'flatfile' or 'leveldb'")
+
+	return initCmd
+}
@@ -13,6 +13,16 @@ const flagDataDir = "datadir"
 const flagMiner = "miner"
 const flagPort = "port"
 const flagIP = "ip"
+const flagStorage = "storage"
+const flagMiningWorkers = "miningWorkers"
+const flagNode = "node"
+const flagBootstrap = "bootstrap"
+const flagFrom = "from"
+const flagTo = "to"
+const flagValue = "value"
+const flagData = "data"
+const flagGasPrice = "gasPrice"
+const flagGenesis = "genesis"
 
 func main() {
 	var tbbCmd = &cobra.Command{
@@ -24,9 +34,11 @@ func main() {
 
 	tbbCmd.AddCommand(versionCmd)
 	tbbCmd.AddCommand(balancesCmd())
+	tbbCmd.AddCommand(initCmd())
 	tbbCmd.AddCommand(runCmd())
 	tbbCmd.AddCommand(migrateCmd())
 	tbbCmd.AddCommand(walletCmd())
+	tbbCmd.AddCommand(mineCmd())
 
 	err := tbbCmd.Execute()
 	if err != nil {
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rawdaGastan/learn_block_chain/client"
+	"github.com/rawdaGastan/learn_block_chain/internal"
+	"github.com/rawdaGastan/learn_block_chain/node"
+	"github.com/spf13/cobra"
+)
+
+// How often the miner checks the node tip, to drop work built on a stale parent
+const tipCheckInterval = 2 * time.Second
+
+// How long the miner waits for pending TXs once the node has no work to hand out
+const noWorkDelay = 10 * time.Second
+
+func mineCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "mine",
+		Short: "Mines the blocks of a node from another process through its work API.",
+		Run: func(cmd *cobra.Command, args []string) {
+			nodeAddress, _ := cmd.Flags().GetString(flagNode)
+			miner, _ := cmd.Flags().GetString(flagMiner)
+			miningWorkers, _ := cmd.Flags().GetInt(flagMiningWorkers)
+
+			mineFromNode(context.Background(), client.New(nodeAddress), internal.NewAccount(miner), miningWorkers)
+		},
+	}
+
+	cmd.Flags().String(flagNode, "127.0.0.1:8080", "address of the node handing out the work")
+	cmd.Flags().String(flagMiner, "", "account paid for the mined blocks")
+	cmd.MarkFlagRequired(flagMiner)
+	cmd.Flags().Int(flagMiningWorkers, node.DefaultMiningWorkers, "number of goroutines mining blocks")
+
+	return cmd
+}
+
+// mineFromNode solves the work of the node until the context is done, restarting whenever the node tip changes.
+func mineFromNode(ctx context.Context, c *client.Client, miner common.Address, workers int) {
+	for ctx.Err() == nil {
+		work, err := c.Work(ctx, miner)
+		if err != nil {
+			fmt.Printf("No work to mine. %s\n", err)
+			time.Sleep(noWorkDelay)
+			continue
+		}
+
+		miningCtx, stopMining := context.WithCancel(ctx)
+		go watchTip(miningCtx, c, work.Header.Parent, stopMining)
+
+		solution, _, err := node.MineWork(miningCtx, work, workers)
+		stopMining()
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			continue
+		}
+
+		res, err := c.SubmitWork(ctx, solution)
+		if err != nil {
+			fmt.Printf("ERROR: unable to submit work '%s'. %s\n", solution.ID.Hex(), err)
+			continue
+		}
+
+		fmt.Printf("Submitted Block '%s', canonical: %t\n", res.Hash.Hex(), res.Canonical)
+	}
+}
+
+// watchTip stops the mining once the node tip is not the parent of the work anymore.
+func watchTip(ctx context.Context, c *client.Client, parent internal.Hash, stopMining context.CancelFunc) {
+	ticker := time.NewTicker(tipCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			status, err := c.Status(ctx)
+			if err == nil && status.Hash != parent {
+				stopMining()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
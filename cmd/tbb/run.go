@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/rawdaGastan/learn_block_chain/internal"
 	"github.com/rawdaGastan/learn_block_chain/node"
 	"github.com/spf13/cobra"
@@ -20,19 +23,29 @@ func runCmd() *cobra.Command {
 			miner, _ := cmd.Flags().GetString(flagMiner)
 			ip, _ := cmd.Flags().GetString(flagIP)
 			port, _ := cmd.Flags().GetUint64(flagPort)
+			storage, _ := cmd.Flags().GetString(flagStorage)
+			miningWorkers, _ := cmd.Flags().GetInt(flagMiningWorkers)
+			bootstrapAddresses, _ := cmd.Flags().GetStringSlice(flagBootstrap)
 
 			fmt.Println("Launching TBB node and its HTTP API...")
 
-			bootstrap := node.NewPeerNode(
-				"127.0.0.1",
-				8080,
-				true,
-				false,
-				internal.NewAccount("rawda"),
-			)
+			if storage != "" {
+				err := internal.InitStorage(getDataDirFromCmd(cmd), storage)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+			}
+
+			bootstraps, err := bootstrapPeers(bootstrapAddresses)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
 
-			n := node.New(getDataDirFromCmd(cmd), ip, port, internal.NewAccount(miner), bootstrap)
-			err := n.Run(context.Background())
+			n := node.New(getDataDirFromCmd(cmd), ip, port, internal.NewAccount(miner), bootstraps...)
+			n.SetMiningWorkers(miningWorkers)
+			err = n.Run(context.Background())
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
@@ -46,6 +59,29 @@ func runCmd() *cobra.Command {
 	runCmd.MarkFlagRequired(flagPort)
 
 	runCmd.Flags().String(flagIP, "127.0.0.1", "ip")
+	runCmd.Flags().Int(flagMiningWorkers, node.DefaultMiningWorkers, "number of goroutines mining blocks, 0 leaves mining to 'tbb mine' processes")
+	runCmd.Flags().String(flagStorage, "", "storage backend of the data dir: 'flatfile' or 'leveldb'")
+	runCmd.Flags().StringSlice(flagBootstrap, []string{"127.0.0.1:8080"}, "'ip:port' addresses of the bootstrap peers, separated by commas")
 
 	return runCmd
 }
+
+// bootstrapPeers parses the 'ip:port' addresses of the bootstrap peers, their accounts are learnt in the handshake.
+func bootstrapPeers(addresses []string) ([]node.PeerNode, error) {
+	peers := make([]node.PeerNode, 0, len(addresses))
+	for _, address := range addresses {
+		ip, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap peer '%s'. %s", address, err)
+		}
+
+		peerPort, err := strconv.ParseUint(port, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port of bootstrap peer '%s'. %s", address, err)
+		}
+
+		peers = append(peers, node.NewPeerNode(ip, peerPort, true, false, common.Address{}))
+	}
+
+	return peers, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -9,6 +10,8 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/console/prompt"
+	"github.com/rawdaGastan/learn_block_chain/client"
+	"github.com/rawdaGastan/learn_block_chain/internal"
 	"github.com/rawdaGastan/learn_block_chain/wallet"
 	"github.com/spf13/cobra"
 )
@@ -26,6 +29,7 @@ func walletCmd() *cobra.Command {
 
 	walletCmd.AddCommand(walletNewAccountCmd())
 	walletCmd.AddCommand(walletPrintPrivKeyCmd())
+	walletCmd.AddCommand(walletSendTxCmd())
 
 	return walletCmd
 }
@@ -83,6 +87,60 @@ func walletPrintPrivKeyCmd() *cobra.Command {
 	return cmd
 }
 
+func walletSendTxCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "send",
+		Short: "Signs a TX with a keystore account and submits it to a node, the password never leaves this machine.",
+		Run: func(cmd *cobra.Command, args []string) {
+			nodeAddress, _ := cmd.Flags().GetString(flagNode)
+			fromRaw, _ := cmd.Flags().GetString(flagFrom)
+			toRaw, _ := cmd.Flags().GetString(flagTo)
+			value, _ := cmd.Flags().GetUint(flagValue)
+			data, _ := cmd.Flags().GetString(flagData)
+			gasPrice, _ := cmd.Flags().GetUint(flagGasPrice)
+
+			from := internal.NewAccount(fromRaw)
+
+			nodeClient := client.New(nodeAddress)
+
+			nonceRes, err := nodeClient.Nonce(context.Background(), from)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			tx := internal.NewTx(from, internal.NewAccount(toRaw), internal.TxGas, gasPrice, value, nonceRes.Nonce, data)
+
+			password := getPassPhrase("Please enter a password to decrypt the wallet:", false)
+			signedTx, err := wallet.SignTxWithKeystoreAccount(tx, nonceRes.ChainID, from, password, wallet.GetKeystoreDirPath(getDataDirFromCmd(cmd)))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			submitRes, err := nodeClient.SubmitTx(context.Background(), signedTx)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("TX submitted: %s\n", submitRes.Hash.Hex())
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().String(flagNode, "127.0.0.1:8080", "address of the node the TX is submitted to")
+	cmd.Flags().String(flagFrom, "", "sender account, must be in the keystore of the data dir")
+	cmd.MarkFlagRequired(flagFrom)
+	cmd.Flags().String(flagTo, "", "receiver account")
+	cmd.MarkFlagRequired(flagTo)
+	cmd.Flags().Uint(flagValue, 0, "amount of TBB to transfer")
+	cmd.Flags().String(flagData, "", "TX data")
+	cmd.Flags().Uint(flagGasPrice, internal.TxGasPriceDefault, "TBB paid to the miner per gas, TXs paying more are mined first")
+
+	return cmd
+}
+
 func getPassPhrase(promptIn string, confirmation bool) string {
 	password, err := prompt.Stdin.PromptPassword(promptIn)
 	if err != nil {
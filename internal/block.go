@@ -19,6 +19,10 @@ func (h Hash) MarshalText() ([]byte, error) {
 }
 
 func (h *Hash) UnmarshalText(data []byte) error {
+	if len(data) != hex.EncodedLen(len(h)) {
+		return fmt.Errorf("invalid hash of %d hex characters, expected %d", len(data), hex.EncodedLen(len(h)))
+	}
+
 	_, err := hex.Decode(h[:], data)
 	return err
 }
@@ -28,12 +32,18 @@ type Block struct {
 	TXs    []SignedTx  `json:"payload"`
 }
 
+// BlockHeader is what the miners hash, ExtraNonce widens the searched space once all the Nonce values are tried.
+//
+// ExtraNonce is omitted while zero, so the hash of the blocks mined before it existed doesn't change.
 type BlockHeader struct {
-	Parent Hash           `json:"parent"`
-	Number uint64         `json:"number"`
-	Nonce  uint32         `json:"nonce"`
-	Time   uint64         `json:"time"`
-	Miner  common.Address `json:"miner"`
+	Parent     Hash           `json:"parent"`
+	Number     uint64         `json:"number"`
+	Nonce      uint32         `json:"nonce"`
+	ExtraNonce uint64         `json:"extra_nonce,omitempty"`
+	Time       uint64         `json:"time"`
+	Difficulty uint64         `json:"difficulty"`
+	Miner      common.Address `json:"miner"`
+	MerkleRoot Hash           `json:"merkle_root"`
 }
 
 type BlockFS struct {
@@ -41,24 +51,39 @@ type BlockFS struct {
 	Value Block `json:"block"`
 }
 
-func NewBlock(parent Hash, time uint64, number uint64, nonce uint32, miner common.Address, txs []SignedTx) Block {
-	return Block{BlockHeader{parent, number, nonce, time, miner}, txs}
+type BlockHeaderFS struct {
+	Key   Hash        `json:"hash"`
+	Value BlockHeader `json:"header"`
 }
 
-func IsBlockHashValid(hash Hash) bool {
-	return fmt.Sprintf("%x", hash[0]) == "0" &&
-		fmt.Sprintf("%x", hash[1]) == "0" &&
-		fmt.Sprintf("%x", hash[2]) == "0" &&
-		fmt.Sprintf("%x", hash[3]) != "0"
+func NewBlock(parent Hash, time uint64, number uint64, nonce uint32, difficulty uint64, miner common.Address, txs []SignedTx) Block {
+	merkleRoot, _ := TxsMerkleRoot(txs)
+
+	return Block{BlockHeader{parent, number, nonce, 0, time, difficulty, miner, merkleRoot}, txs}
 }
 
+// Hash identifies the block by its header only, the TXs are committed to through the header Merkle root.
 func (b Block) Hash() (Hash, error) {
-	blockJson, err := json.Marshal(b)
+	return b.Header.Hash()
+}
+
+func (h BlockHeader) Hash() (Hash, error) {
+	headerJson, err := json.Marshal(h)
 	if err != nil {
 		return Hash{}, err
 	}
 
-	return sha256.Sum256(blockJson), nil
+	return sha256.Sum256(headerJson), nil
+}
+
+// Fees is the sum of the fees paid by the block TXs to its miner.
+func (b Block) Fees() uint {
+	fees := uint(0)
+	for _, tx := range b.TXs {
+		fees += tx.Fee()
+	}
+
+	return fees
 }
 
 func (h Hash) Hex() string {
@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+func TestHash_UnmarshalText(t *testing.T) {
+	hash := Hash(sha256.Sum256([]byte("block")))
+
+	decoded := Hash{}
+	err := decoded.UnmarshalText([]byte(hash.Hex()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded != hash {
+		t.Fatal("the decoded hash should match the encoded one")
+	}
+
+	for _, text := range []string{"", hash.Hex()[:10], hash.Hex() + "00", strings.Repeat("ab", 100)} {
+		err = decoded.UnmarshalText([]byte(text))
+		if err == nil {
+			t.Fatalf("a hash of %d hex characters should be rejected", len(text))
+		}
+	}
+}
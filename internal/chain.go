@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"fmt"
+)
+
+// DenseLocatorHashes is the number of latest blocks all listed by a block locator, see State.BlockLocator
+const DenseLocatorHashes = 10
+
+// reorgEvent is a chain reorganization waiting to be reported to the reorg handler.
+type reorgEvent struct {
+	orphaned []Block
+	adopted  []Block
+}
+
+// isBetterBranch tells if the branch ending with the given block should replace the canonical chain.
+//
+// The heaviest branch, with the highest total difficulty, wins. On equal weight the first seen one is kept.
+func (s *State) isBetterBranch(b Block) bool {
+	if !s.hasGenesisBlock {
+		return true
+	}
+
+	branchDifficulty := s.totalDifficulty[b.Header.Parent] + b.Header.Difficulty
+
+	return branchDifficulty > s.totalDifficulty[s.latestBlockHash]
+}
+
+// branchTo collects the blocks from the fork point with the canonical chain up to the given tip.
+//
+// The fork point is the number of blocks shared with the canonical chain.
+func (s *State) branchTo(tip Block, tipHash Hash) ([]Block, []Hash, uint64, error) {
+	blocks := []Block{tip}
+	hashes := []Hash{tipHash}
+
+	b := tip
+	for !b.Header.Parent.IsEmpty() && !s.isCanonical(b.Header.Parent, b.Header.Number-1) {
+		parent, err := s.storage.GetBlock(b.Header.Parent)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		hashes = append([]Hash{b.Header.Parent}, hashes...)
+		blocks = append([]Block{parent}, blocks...)
+		b = parent
+	}
+
+	return blocks, hashes, b.Header.Number, nil
+}
+
+func (s *State) isCanonical(hash Hash, number uint64) bool {
+	return number < uint64(len(s.chain)) && s.chain[number] == hash
+}
+
+// reorg switches the canonical chain to the branch ending with the given block.
+//
+// The blocks above the fork point are reverted from a copy of the state and the new branch
+// is applied on top of it. The state is only modified if the whole new branch is valid.
+func (s *State) reorg(tip Block, tipHash Hash, persist bool) error {
+	branch, branchHashes, forkPoint, err := s.branchTo(tip, tipHash)
+	if err != nil {
+		return err
+	}
+
+	orphaned := make([]Block, 0)
+	for _, hash := range s.chain[forkPoint:] {
+		b, err := s.storage.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+
+		orphaned = append(orphaned, b)
+	}
+
+	pendingState := s.copy()
+	for i := len(orphaned) - 1; i >= 0; i-- {
+		err = revertBlock(orphaned[i], pendingState)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, b := range branch {
+		err := applyBlock(b, pendingState)
+		if err != nil {
+			return fmt.Errorf("invalid branch ending with block '%s'. %s", tipHash.Hex(), err.Error())
+		}
+	}
+
+	err = s.persistCanonicalChange(tip, tipHash, pendingState, orphaned, branch, branchHashes, persist)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Reorganizing chain from Block '%s' to Block '%s', %d blocks orphaned\n", s.latestBlockHash.Hex(), tipHash.Hex(), len(orphaned))
+
+	chain := append(s.chain[:forkPoint:forkPoint], branchHashes...)
+
+	s.storeHeader(tipHash, tip.Header)
+	s.commit(pendingState, chain)
+
+	if s.reorgHandler != nil && len(orphaned) > 0 {
+		s.reorgEvents = append(s.reorgEvents, reorgEvent{orphaned, branch})
+	}
+
+	return nil
+}
+
+// revertBlock undoes the balances and nonces changes of the latest block of the state.
+func revertBlock(b Block, s *State) error {
+	s.Balances[b.Header.Miner] -= BlockReward + b.Fees()
+
+	for i := len(b.TXs) - 1; i >= 0; i-- {
+		tx := b.TXs[i]
+
+		s.Balances[tx.To] -= tx.Value
+		s.Balances[tx.From] += tx.Cost()
+
+		if tx.Nonce <= 1 {
+			delete(s.Account2Nonce, tx.From)
+		} else {
+			s.Account2Nonce[tx.From] = tx.Nonce - 1
+		}
+	}
+
+	if b.Header.Parent.IsEmpty() {
+		s.latestBlock = Block{}
+		s.latestBlockHash = Hash{}
+		s.hasGenesisBlock = false
+
+		return nil
+	}
+
+	parent, err := s.storage.GetBlock(b.Header.Parent)
+	if err != nil {
+		return err
+	}
+
+	s.latestBlock = parent
+	s.latestBlockHash = b.Header.Parent
+
+	return nil
+}
@@ -3,44 +3,741 @@ package internal
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
-	"reflect"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
-func GetBlocksAfter(blockHash Hash, dataDir string) ([]Block, error) {
-	f, err := os.OpenFile(getBlocksDbFilePath(dataDir), os.O_RDONLY, 0600)
+// BlockIndexFS locates a block persisted in the block.db file.
+//
+// The index entries are stored in the block.idx file, next to block.db, in the same order as the blocks.
+type BlockIndexFS struct {
+	Key    Hash        `json:"hash"`
+	Header BlockHeader `json:"header"`
+	Offset int64       `json:"offset"`
+	Size   int64       `json:"size"`
+}
+
+// TxIndexFS is an entry of the tx.idx file, an empty block hash removes the TX from the index.
+type TxIndexFS struct {
+	Key   Hash       `json:"hash"`
+	Value TxLocation `json:"location"`
+}
+
+// AccountTxIndexFS is an entry of the account.idx file, an empty block hash removes the TX from the account history.
+type AccountTxIndexFS struct {
+	Account common.Address `json:"account"`
+	Key     Hash           `json:"hash"`
+	Value   TxLocation     `json:"location"`
+}
+
+// flatFileStorage is the append only block.db file together with its indexes.
+//
+// Blocks are looked up by hash without scanning block.db.
+type flatFileStorage struct {
+	dataDir      string
+	dbFile       *os.File
+	indexFile    *os.File
+	txsFile      *os.File
+	accountsFile *os.File
+	dbSize       int64
+
+	index map[Hash]BlockIndexFS
+	// Hashes of the persisted blocks in the order they were written
+	order []Hash
+	txs   map[Hash]TxLocation
+	// TXs of each account ordered by position in the canonical chain
+	accounts map[common.Address][]TxIndexFS
+}
+
+func openFlatFileStorage(dataDir string) (*flatFileStorage, error) {
+	db := &flatFileStorage{
+		dataDir:  dataDir,
+		index:    make(map[Hash]BlockIndexFS),
+		order:    make([]Hash, 0),
+		txs:      make(map[Hash]TxLocation),
+		accounts: make(map[common.Address][]TxIndexFS),
+	}
+
+	var err error
+
+	db.dbFile, err = os.OpenFile(getBlocksDbFilePath(dataDir), os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	db.indexFile, err = os.OpenFile(getBlocksIndexFilePath(dataDir), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	db.txsFile, err = os.OpenFile(getTxsIndexFilePath(dataDir), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	db.accountsFile, err = os.OpenFile(getAccountsIndexFilePath(dataDir), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = db.load()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = db.loadTxs()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = db.loadAccounts()
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
+	return db, nil
+}
+
+// load reads the index and indexes the blocks written to block.db but missing from it,
+// e.g. for data dirs created before the index existed.
+func (db *flatFileStorage) load() error {
+	dbInfo, err := db.dbFile.Stat()
+	if err != nil {
+		return err
+	}
+	db.dbSize = dbInfo.Size()
+
+	indexed := int64(0)
+	indexSize := int64(0)
+
+	reader := bufio.NewReader(db.indexFile)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry BlockIndexFS
+		err = json.Unmarshal(line, &entry)
+		if err != nil {
+			return err
+		}
+
+		// Entries pointing past the end of block.db come from an interrupted write
+		if entry.Offset+entry.Size+1 > db.dbSize {
+			break
+		}
+
+		db.index[entry.Key] = entry
+		db.order = append(db.order, entry.Key)
+		indexed = entry.Offset + entry.Size + 1
+		indexSize += int64(len(line))
+	}
+
+	err = db.indexFile.Truncate(indexSize)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.indexFile.Seek(indexSize, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return db.reindexFrom(indexed)
+}
+
+func (db *flatFileStorage) reindexFrom(offset int64) error {
+	if offset >= db.dbSize {
+		return nil
+	}
+
+	fmt.Printf("Indexing blocks from offset %d of block.db\n", offset)
+
+	reader := bufio.NewReader(io.NewSectionReader(db.dbFile, offset, db.dbSize-offset))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		size := int64(len(line)) - 1
+		if size > 0 {
+			var blockFs BlockFS
+			err = json.Unmarshal(line, &blockFs)
+			if err != nil {
+				return err
+			}
+
+			err = db.writeIndex(BlockIndexFS{blockFs.Key, blockFs.Value.Header, offset, size})
+			if err != nil {
+				return err
+			}
+		}
+
+		offset += int64(len(line))
+	}
+}
+
+func (db *flatFileStorage) writeIndex(entry BlockIndexFS) error {
+	entryJson, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.indexFile.Write(append(entryJson, '\n'))
+	if err != nil {
+		return err
+	}
+
+	db.index[entry.Key] = entry
+	db.order = append(db.order, entry.Key)
+
+	return nil
+}
+
+func (db *flatFileStorage) loadTxs() error {
+	reader := bufio.NewReader(db.txsFile)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry TxIndexFS
+		err = json.Unmarshal(line, &entry)
+		if err != nil {
+			return err
+		}
+
+		if entry.Value.BlockHash.IsEmpty() {
+			delete(db.txs, entry.Key)
+			continue
+		}
+
+		db.txs[entry.Key] = entry.Value
+	}
+}
+
+func (db *flatFileStorage) writeTx(entry TxIndexFS) error {
+	entryJson, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.txsFile.Write(append(entryJson, '\n'))
+
+	return err
+}
+
+func (db *flatFileStorage) loadAccounts() error {
+	reader := bufio.NewReader(db.accountsFile)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry AccountTxIndexFS
+		err = json.Unmarshal(line, &entry)
+		if err != nil {
+			return err
+		}
+
+		db.removeAccountTx(entry.Account, entry.Key)
+
+		if !entry.Value.BlockHash.IsEmpty() {
+			db.insertAccountTx(entry.Account, TxIndexFS{entry.Key, entry.Value})
+		}
+	}
+}
+
+func (db *flatFileStorage) writeAccountTx(entry AccountTxIndexFS) error {
+	entryJson, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.accountsFile.Write(append(entryJson, '\n'))
+
+	return err
+}
+
+// insertAccountTx keeps the account history ordered by position in the canonical chain.
+func (db *flatFileStorage) insertAccountTx(account common.Address, entry TxIndexFS) {
+	history := db.accounts[account]
+
+	i := sort.Search(len(history), func(i int) bool {
+		return entry.Value.before(history[i].Value)
+	})
+
+	history = append(history, TxIndexFS{})
+	copy(history[i+1:], history[i:])
+	history[i] = entry
+
+	db.accounts[account] = history
+}
+
+func (db *flatFileStorage) removeAccountTx(account common.Address, txHash Hash) {
+	history := db.accounts[account]
+
+	for i, entry := range history {
+		if entry.Key == txHash {
+			db.accounts[account] = append(history[:i], history[i+1:]...)
+			return
+		}
+	}
+}
+
+func (db *flatFileStorage) findAccountTx(account common.Address, txHash Hash) (TxLocation, bool) {
+	for _, entry := range db.accounts[account] {
+		if entry.Key == txHash {
+			return entry.Value, true
+		}
+	}
+
+	return TxLocation{}, false
+}
+
+func (db *flatFileStorage) PutBlock(blockHash Hash, b Block) error {
+	blockFs := BlockFS{blockHash, b}
+
+	blockFsJson, err := json.Marshal(blockFs)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Persisting new Block to disk:\n")
+	fmt.Printf("\t%s\n", blockFsJson)
+
+	_, err = db.dbFile.Write(append(blockFsJson, '\n'))
+	if err != nil {
+		return err
+	}
+
+	entry := BlockIndexFS{blockHash, b.Header, db.dbSize, int64(len(blockFsJson))}
+	db.dbSize += int64(len(blockFsJson)) + 1
+
+	return db.writeIndex(entry)
+}
+
+func (db *flatFileStorage) GetBlock(blockHash Hash) (Block, error) {
+	entry, ok := db.index[blockHash]
+	if !ok {
+		return Block{}, fmt.Errorf("block '%s' not found", blockHash.Hex())
+	}
+
+	blockFsJson := make([]byte, entry.Size)
+	_, err := db.dbFile.ReadAt(blockFsJson, entry.Offset)
+	if err != nil {
+		return Block{}, err
+	}
+
+	var blockFs BlockFS
+	err = json.Unmarshal(blockFsJson, &blockFs)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return blockFs.Value, nil
+}
+
+func (db *flatFileStorage) GetHeader(blockHash Hash) (BlockHeader, error) {
+	entry, ok := db.index[blockHash]
+	if !ok {
+		return BlockHeader{}, fmt.Errorf("block '%s' not found", blockHash.Hex())
+	}
+
+	return entry.Header, nil
+}
+
+func (db *flatFileStorage) BlockHashes() ([]Hash, error) {
+	return append([]Hash{}, db.order...), nil
+}
+
+func (db *flatFileStorage) PutTxLocation(txHash Hash, location TxLocation) error {
+	if current, ok := db.txs[txHash]; ok && current == location {
+		return nil
+	}
+
+	err := db.writeTx(TxIndexFS{txHash, location})
+	if err != nil {
+		return err
+	}
+
+	db.txs[txHash] = location
+
+	return nil
+}
+
+func (db *flatFileStorage) DeleteTxLocation(txHash Hash) error {
+	if _, ok := db.txs[txHash]; !ok {
+		return nil
+	}
+
+	err := db.writeTx(TxIndexFS{Key: txHash})
+	if err != nil {
+		return err
+	}
+
+	delete(db.txs, txHash)
+
+	return nil
+}
+
+func (db *flatFileStorage) GetTxLocation(txHash Hash) (TxLocation, error) {
+	location, ok := db.txs[txHash]
+	if !ok {
+		return TxLocation{}, fmt.Errorf("TX '%s' not found", txHash.Hex())
+	}
+
+	return location, nil
+}
+
+func (db *flatFileStorage) PutAccountTx(account common.Address, txHash Hash, location TxLocation) error {
+	if current, ok := db.findAccountTx(account, txHash); ok && current == location {
+		return nil
+	}
+
+	err := db.writeAccountTx(AccountTxIndexFS{account, txHash, location})
+	if err != nil {
+		return err
+	}
+
+	db.removeAccountTx(account, txHash)
+	db.insertAccountTx(account, TxIndexFS{txHash, location})
+
+	return nil
+}
+
+// DeleteAccountTx removes the TX from the account history wherever it is, the location is only needed by other backends.
+func (db *flatFileStorage) DeleteAccountTx(account common.Address, txHash Hash, location TxLocation) error {
+	if _, ok := db.findAccountTx(account, txHash); !ok {
+		return nil
+	}
+
+	err := db.writeAccountTx(AccountTxIndexFS{Account: account, Key: txHash})
+	if err != nil {
+		return err
+	}
+
+	db.removeAccountTx(account, txHash)
+
+	return nil
+}
+
+func (db *flatFileStorage) GetAccountTxs(account common.Address, offset int, limit int) ([]Hash, int, error) {
+	history := db.accounts[account]
+	hashes := make([]Hash, 0)
+
+	for i := len(history) - 1 - offset; i >= 0 && len(hashes) < limit; i-- {
+		hashes = append(hashes, history[i].Key)
+	}
+
+	return hashes, len(history), nil
+}
+
+// PutSnapshot replaces the state.json file.
+func (db *flatFileStorage) PutSnapshot(snapshot Snapshot) error {
+	snapshotJson, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(getSnapshotFilePath(db.dataDir), snapshotJson)
+}
+
+func (db *flatFileStorage) GetSnapshot() (Snapshot, error) {
+	if !FileExist(getSnapshotFilePath(db.dataDir)) {
+		return Snapshot{}, nil
+	}
+
+	snapshotJson, err := os.ReadFile(getSnapshotFilePath(db.dataDir))
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	var snapshot Snapshot
+	err = json.Unmarshal(snapshotJson, &snapshot)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return snapshot, nil
+}
+
+func (db *flatFileStorage) Close() error {
+	for _, f := range []*os.File{db.dbFile, db.indexFile, db.txsFile, db.accountsFile} {
+		if f != nil {
+			f.Close()
+		}
+	}
+
+	return nil
+}
+
+// GetBlock returns a known block, on any branch, by its hash.
+func (s *State) GetBlock(blockHash Hash) (Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.storage.GetBlock(blockHash)
+}
+
+// GetBlockByNumber returns the canonical chain block of the given number.
+func (s *State) GetBlockByNumber(number uint64) (Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if number >= uint64(len(s.chain)) {
+		return Block{}, fmt.Errorf("block number '%d' not found", number)
+	}
+
+	return s.storage.GetBlock(s.chain[number])
+}
+
+// GetTxLocation returns the canonical chain block containing the TX and its position in it.
+func (s *State) GetTxLocation(txHash Hash) (TxLocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.storage.GetTxLocation(txHash)
+}
+
+// GetLatestHeaders returns the headers of the last blocks of the canonical chain, the latest first.
+func (s *State) GetLatestHeaders(count int) []BlockHeaderFS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	headers := make([]BlockHeaderFS, 0)
+	for i := len(s.chain) - 1; i >= 0 && len(headers) < count; i-- {
+		headers = append(headers, BlockHeaderFS{s.chain[i], s.headers[s.chain[i]]})
+	}
+
+	return headers
+}
+
+// GetTx returns a TX of the canonical chain with the block containing it and its position in it.
+func (s *State) GetTx(txHash Hash) (LocatedTx, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.getTx(txHash)
+}
+
+// GetAccountTxs returns a page of the TXs sent or received by the account, the most recent first,
+// and how many TXs the account has in total.
+func (s *State) GetAccountTxs(account common.Address, offset int, limit int) ([]LocatedTx, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hashes, total, err := s.storage.GetAccountTxs(account, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	txs := make([]LocatedTx, 0, len(hashes))
+	for _, txHash := range hashes {
+		tx, err := s.getTx(txHash)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		txs = append(txs, tx)
+	}
+
+	return txs, total, nil
+}
+
+func (s *State) getTx(txHash Hash) (LocatedTx, error) {
+	location, err := s.storage.GetTxLocation(txHash)
+	if err != nil {
+		return LocatedTx{}, err
+	}
+
+	b, err := s.storage.GetBlock(location.BlockHash)
+	if err != nil {
+		return LocatedTx{}, err
+	}
+
+	if location.Index >= len(b.TXs) {
+		return LocatedTx{}, fmt.Errorf("TX '%s' not found in block '%s'", txHash.Hex(), location.BlockHash.Hex())
+	}
+
+	return LocatedTx{txHash, b.TXs[location.Index], location}, nil
+}
+
+// GetTxProof returns the hash and header of the canonical chain block containing the TX,
+// with the Merkle proof of its inclusion in the header Merkle root.
+func (s *State) GetTxProof(txHash Hash) (Hash, BlockHeader, []MerkleProofStep, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	location, err := s.storage.GetTxLocation(txHash)
+	if err != nil {
+		return Hash{}, BlockHeader{}, nil, err
+	}
+
+	b, err := s.storage.GetBlock(location.BlockHash)
+	if err != nil {
+		return Hash{}, BlockHeader{}, nil, err
+	}
+
+	proof, err := b.TxProof(txHash)
+	if err != nil {
+		return Hash{}, BlockHeader{}, nil, err
+	}
+
+	return location.BlockHash, b.Header, proof, nil
+}
+
+// GetBlocksAfter returns at most limit canonical chain blocks following the given block.
+//
+// An empty hash returns the canonical chain from its first block.
+func (s *State) GetBlocksAfter(blockHash Hash, limit int) ([]Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	blocks := make([]Block, 0)
-	shouldStartCollecting := false
 
-	if reflect.DeepEqual(blockHash, Hash{}) {
-		shouldStartCollecting = true
+	from, ok := s.canonicalIndexAfter(blockHash)
+	if !ok {
+		return blocks, nil
 	}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
+	for _, hash := range s.chain[from:s.canonicalIndexUntil(from, limit)] {
+		b, err := s.storage.GetBlock(hash)
+		if err != nil {
 			return nil, err
 		}
 
-		var blockFs BlockFS
-		err = json.Unmarshal(scanner.Bytes(), &blockFs)
+		blocks = append(blocks, b)
+	}
+
+	return blocks, nil
+}
+
+// GetHeadersAfter returns at most limit canonical chain headers following the given block.
+//
+// An empty hash returns the canonical chain from its first block,
+// a block unknown or out of the canonical chain is an error.
+func (s *State) GetHeadersAfter(blockHash Hash, limit int) ([]BlockHeaderFS, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	from, ok := s.canonicalIndexAfter(blockHash)
+	if !ok {
+		return nil, fmt.Errorf("block '%s' is not part of the canonical chain", blockHash.Hex())
+	}
+
+	headers := make([]BlockHeaderFS, 0)
+	for _, hash := range s.chain[from:s.canonicalIndexUntil(from, limit)] {
+		headers = append(headers, BlockHeaderFS{hash, s.headers[hash]})
+	}
+
+	return headers, nil
+}
+
+// GetBlocks returns known blocks, on any branch, in the order of the given hashes.
+func (s *State) GetBlocks(hashes []Hash) ([]Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blocks := make([]Block, 0, len(hashes))
+	for _, hash := range hashes {
+		b, err := s.storage.GetBlock(hash)
 		if err != nil {
 			return nil, err
 		}
 
-		if shouldStartCollecting {
-			blocks = append(blocks, blockFs.Value)
-			continue
+		blocks = append(blocks, b)
+	}
+
+	return blocks, nil
+}
+
+// BlockLocator lists canonical chain hashes from the tip back to the first block, the latest first.
+//
+// The DenseLocatorHashes latest blocks are all listed, then the step between two hashes doubles,
+// so a peer finds the fork point of a long chain from a few hashes.
+func (s *State) BlockLocator() []Hash {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	locator := make([]Hash, 0)
+	step := 1
+
+	for i := len(s.chain) - 1; i > 0; i -= step {
+		locator = append(locator, s.chain[i])
+
+		if len(locator) >= DenseLocatorHashes {
+			step *= 2
 		}
+	}
+
+	if len(s.chain) > 0 {
+		locator = append(locator, s.chain[0])
+	}
 
-		if blockHash == blockFs.Key {
-			shouldStartCollecting = true
+	return locator
+}
+
+// FindCommonAncestor returns the header of the first block of the locator found in the canonical chain.
+func (s *State) FindCommonAncestor(locator []Hash) (BlockHeaderFS, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, hash := range locator {
+		header, ok := s.headers[hash]
+		if ok && s.isCanonical(hash, header.Number) {
+			return BlockHeaderFS{hash, header}, nil
 		}
 	}
 
-	return blocks, nil
+	return BlockHeaderFS{}, fmt.Errorf("no block of the locator is part of the canonical chain")
+}
+
+// canonicalIndexUntil returns the end of a page of the canonical chain, capped to its length.
+func (s *State) canonicalIndexUntil(from int, limit int) int {
+	if from+limit > len(s.chain) {
+		return len(s.chain)
+	}
+
+	return from + limit
+}
+
+// canonicalIndexAfter returns the position in the canonical chain following the given block.
+func (s *State) canonicalIndexAfter(blockHash Hash) (int, bool) {
+	if blockHash.IsEmpty() {
+		return 0, true
+	}
+
+	header, ok := s.headers[blockHash]
+	if !ok || !s.isCanonical(blockHash, header.Number) {
+		return 0, false
+	}
+
+	return int(header.Number) + 1, true
 }
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"math/big"
+)
+
+const DefaultDifficulty uint64 = 1 << 24
+const DefaultBlockInterval uint64 = 10
+const DefaultRetargetInterval uint64 = 10
+
+// MaxBlockTimeDrift is how far in the future of the local clock, in seconds, a block time can be.
+// The retargeting trusts the block times, a miner couldn't lower the difficulty with a time far ahead.
+const MaxBlockTimeDrift uint64 = 60
+
+// ConsensusParams configures the proof-of-work of the chain.
+//
+// Zero values fall back to the defaults.
+type ConsensusParams struct {
+	// Difficulty of the first block
+	Difficulty uint64 `json:"difficulty"`
+	// Target time between two blocks, in seconds
+	BlockInterval uint64 `json:"block_interval"`
+	// Number of blocks between two difficulty adjustments
+	RetargetInterval uint64 `json:"retarget_interval"`
+}
+
+func (c ConsensusParams) withDefaults() ConsensusParams {
+	if c.Difficulty == 0 {
+		c.Difficulty = DefaultDifficulty
+	}
+
+	if c.BlockInterval == 0 {
+		c.BlockInterval = DefaultBlockInterval
+	}
+
+	if c.RetargetInterval == 0 {
+		c.RetargetInterval = DefaultRetargetInterval
+	}
+
+	return c
+}
+
+// NextDifficulty returns the difficulty required for the next block of the canonical chain.
+func (s *State) NextDifficulty() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.nextBlockDifficulty()
+}
+
+func (s *State) nextBlockDifficulty() uint64 {
+	if !s.hasGenesisBlock {
+		return s.consensus.Difficulty
+	}
+
+	return s.nextDifficulty(s.latestBlock.Header)
+}
+
+// nextDifficulty returns the difficulty required for a child of the given block.
+//
+// Every RetargetInterval blocks the difficulty is scaled by the ratio between the
+// expected and the observed time it took to mine the previous interval.
+// A single adjustment is limited to a factor of 4 in both directions.
+func (s *State) nextDifficulty(parent BlockHeader) uint64 {
+	return s.nextDifficultyOn(parent, s.getHeader)
+}
+
+// nextDifficultyOn is nextDifficulty with the ancestors of the parent looked up through getHeader,
+// e.g. among headers not added to the state yet.
+func (s *State) nextDifficultyOn(parent BlockHeader, getHeader headerLookup) uint64 {
+	number := parent.Number + 1
+	interval := s.consensus.RetargetInterval
+
+	if number%interval != 0 || number < interval {
+		return parent.Difficulty
+	}
+
+	first := parent
+	for i := uint64(1); i < interval; i++ {
+		first, _ = getHeader(first.Parent)
+	}
+
+	expected := s.consensus.BlockInterval * (interval - 1)
+	actual := uint64(0)
+	if parent.Time > first.Time {
+		actual = parent.Time - first.Time
+	}
+
+	if actual < expected/4 {
+		actual = expected / 4
+	}
+	if actual > expected*4 {
+		actual = expected * 4
+	}
+	if actual == 0 {
+		actual = 1
+	}
+
+	next := new(big.Int).SetUint64(parent.Difficulty)
+	next.Mul(next, new(big.Int).SetUint64(expected))
+	next.Div(next, new(big.Int).SetUint64(actual))
+
+	if !next.IsUint64() {
+		return ^uint64(0)
+	}
+
+	if next.Uint64() == 0 {
+		return 1
+	}
+
+	return next.Uint64()
+}
+
+// IsBlockHashValid tells if the hash satisfies the proof-of-work of the given difficulty.
+//
+// The hash, read as a big-endian number, must not exceed (2^256 - 1) / difficulty.
+func IsBlockHashValid(hash Hash, difficulty uint64) bool {
+	if difficulty == 0 {
+		return false
+	}
+
+	return new(big.Int).SetBytes(hash[:]).Cmp(DifficultyToTarget(difficulty)) <= 0
+}
+
+func DifficultyToTarget(difficulty uint64) *big.Int {
+	maxTarget := new(big.Int).Lsh(big.NewInt(1), 256)
+	maxTarget.Sub(maxTarget, big.NewInt(1))
+
+	return maxTarget.Div(maxTarget, new(big.Int).SetUint64(difficulty))
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestNextDifficulty(t *testing.T) {
+	params := ConsensusParams{Difficulty: 1000, BlockInterval: 10, RetargetInterval: 5}
+
+	tests := []struct {
+		name string
+		// Number of the parent block
+		number uint64
+		// Seconds between two consecutive blocks
+		spacing uint64
+		want    uint64
+	}{
+		{"keeps difficulty between retargets", 5, 1, 1000},
+		{"on target", 4, 10, 1000},
+		{"twice too slow", 4, 20, 500},
+		{"twice too fast", 4, 5, 2000},
+		{"limited to 4x harder", 4, 0, 4000},
+		{"limited to 4x easier", 4, 1000, 250},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := &State{headers: make(map[Hash]BlockHeader), consensus: params}
+
+			parent := BlockHeader{}
+			parentHash := Hash{}
+			for i := uint64(0); i <= test.number; i++ {
+				parent = NewBlock(parentHash, i*test.spacing, i, 0, params.Difficulty, NewAccount("0x01"), nil).Header
+				parentHash = Hash{byte(i + 1)}
+				state.headers[parentHash] = parent
+			}
+
+			got := state.nextDifficulty(parent)
+			if got != test.want {
+				t.Fatalf("next difficulty should be %d not %d", test.want, got)
+			}
+		})
+	}
+}
+
+func TestIsBlockHashValid(t *testing.T) {
+	hash := Hash{0x00, 0x0f}
+
+	if !IsBlockHashValid(hash, 1<<8) {
+		t.Fatal("hash below the target should be valid")
+	}
+
+	if IsBlockHashValid(hash, 1<<16) {
+		t.Fatal("hash above the target should not be valid")
+	}
+
+	if IsBlockHashValid(hash, 0) {
+		t.Fatal("zero difficulty should never be valid")
+	}
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"fmt"
 	"os"
 	"os/user"
 	"path"
@@ -9,10 +10,25 @@ import (
 )
 
 func InitDataDirIfNotExists(dataDir string, genesis []byte) error {
-	if fileExist(getGenesisJsonFilePath(dataDir)) {
+	if FileExist(getGenesisJsonFilePath(dataDir)) {
 		return nil
 	}
 
+	return initDataDir(dataDir, genesis)
+}
+
+// InitDataDir creates a data dir for the network described by the genesis.
+//
+// An already initialized data dir is never overwritten.
+func InitDataDir(dataDir string, genesis []byte) error {
+	if FileExist(getGenesisJsonFilePath(dataDir)) {
+		return fmt.Errorf("data dir '%s' is already initialized", dataDir)
+	}
+
+	return initDataDir(dataDir, genesis)
+}
+
+func initDataDir(dataDir string, genesis []byte) error {
 	if err := os.MkdirAll(getDatabaseDirPath(dataDir), os.ModePerm); err != nil {
 		return err
 	}
@@ -37,8 +53,32 @@ func getGenesisJsonFilePath(dataDir string) string {
 func getBlocksDbFilePath(dataDir string) string {
 	return filepath.Join(getDatabaseDirPath(dataDir), "block.db")
 }
+func getBlocksIndexFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "block.idx")
+}
+func getTxsIndexFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "tx.idx")
+}
+func getAccountsIndexFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "account.idx")
+}
+func getSnapshotFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "state.json")
+}
+func getStorageFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "storage")
+}
+func getLevelDBDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "leveldb")
+}
+func GetMempoolFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "mempool.json")
+}
+func GetPeersFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "peers.json")
+}
 
-func fileExist(filePath string) bool {
+func FileExist(filePath string) bool {
 	_, err := os.Stat(filePath)
 	if err != nil && os.IsNotExist(err) {
 		return false
@@ -47,6 +87,18 @@ func fileExist(filePath string) bool {
 	return true
 }
 
+// WriteFileAtomic replaces the file through a temporary file so a crash never leaves half of it.
+func WriteFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+
+	err := os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
 func dirExists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
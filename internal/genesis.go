@@ -1,8 +1,12 @@
 package internal
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -13,28 +17,79 @@ var genesisJson = `
   "chain_id": "the-blockchain-bar-ledger",
   "balances": {
     "0x22ba1F80452E6220c7cc6ea2D1e3EEDDaC5F694A": 1000000
+  },
+  "consensus": {
+    "difficulty": 16777216,
+    "block_interval": 10,
+    "retarget_interval": 10
   }
 }`
 
+// Genesis describes a network: its identity, the initial balances and the consensus rules.
 type Genesis struct {
-	Balances map[common.Address]uint `json:"balances"`
+	Time      time.Time               `json:"genesis_time"`
+	ChainID   string                  `json:"chain_id"`
+	Balances  map[common.Address]uint `json:"balances"`
+	Consensus ConsensusParams         `json:"consensus"`
 }
 
-func loadGenesis(path string) (Genesis, error) {
-	content, err := os.ReadFile(path)
+// ParseGenesis decodes and validates the content of a genesis file.
+func ParseGenesis(content []byte) (Genesis, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	var gen Genesis
+	err := decoder.Decode(&gen)
 	if err != nil {
-		return Genesis{}, err
+		return Genesis{}, fmt.Errorf("invalid genesis. %s", err.Error())
+	}
+
+	if gen.ChainID == "" {
+		return Genesis{}, fmt.Errorf("invalid genesis. 'chain_id' is required")
+	}
+
+	if gen.Time.IsZero() {
+		return Genesis{}, fmt.Errorf("invalid genesis. 'genesis_time' is required")
+	}
+
+	for account := range gen.Balances {
+		if account == (common.Address{}) {
+			return Genesis{}, fmt.Errorf("invalid genesis. balance of an empty account")
+		}
+	}
+
+	return gen, nil
+}
+
+// Hash identifies the network of the genesis, whatever the formatting of its file.
+func (g Genesis) Hash() (Hash, error) {
+	genesisJson, err := json.Marshal(g)
+	if err != nil {
+		return Hash{}, err
 	}
 
-	var loadedGenesis Genesis
-	err = json.Unmarshal(content, &loadedGenesis)
+	return sha256.Sum256(genesisJson), nil
+}
+
+func loadGenesis(path string) (Genesis, error) {
+	content, err := os.ReadFile(path)
 	if err != nil {
 		return Genesis{}, err
 	}
 
-	return loadedGenesis, nil
+	return ParseGenesis(content)
 }
 
+// writeGenesisToDisk writes the given genesis, or the default one when empty, once validated.
 func writeGenesisToDisk(path string, genesis []byte) error {
-	return os.WriteFile(path, []byte(genesisJson), 0644)
+	if len(genesis) == 0 {
+		genesis = []byte(genesisJson)
+	}
+
+	_, err := ParseGenesis(genesis)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, genesis, 0644)
 }
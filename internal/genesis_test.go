@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseGenesis(t *testing.T) {
+	tests := []struct {
+		name    string
+		genesis string
+		valid   bool
+	}{
+		{"default", genesisJson, true},
+		{"without consensus", `{"genesis_time": "2022-01-01T00:00:00Z", "chain_id": "staging", "balances": {}}`, true},
+		{"without chain id", `{"genesis_time": "2022-01-01T00:00:00Z", "balances": {}}`, false},
+		{"without genesis time", `{"chain_id": "staging", "balances": {}}`, false},
+		{"unknown field", `{"genesis_time": "2022-01-01T00:00:00Z", "chain_id": "staging", "balance": {}}`, false},
+		{"malformed", `{"chain_id": `, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseGenesis([]byte(test.genesis))
+			if test.valid && err != nil {
+				t.Fatal(err)
+			}
+
+			if !test.valid && err == nil {
+				t.Fatal("genesis should be rejected")
+			}
+		})
+	}
+}
+
+func TestInitDataDir(t *testing.T) {
+	dataDir, err := os.MkdirTemp("", "tbb_genesis_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveDir(dataDir)
+
+	customGenesis := `{
+  "genesis_time": "2022-01-01T00:00:00Z",
+  "chain_id": "staging",
+  "balances": {"0x00000000000000000000000000000000000000aa": 500},
+  "consensus": {"difficulty": 1}
+}`
+
+	err = InitDataDir(dataDir, []byte(customGenesis))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = InitDataDir(dataDir, []byte(genesisJson))
+	if err == nil {
+		t.Fatal("initialized data dir should not be overwritten")
+	}
+
+	gen, err := loadGenesis(getGenesisJsonFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gen.ChainID != "staging" || gen.Balances[NewAccount("0x00000000000000000000000000000000000000aa")] != 500 || gen.Consensus.Difficulty != 1 {
+		t.Fatal("the custom genesis should have been installed")
+	}
+}
@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Keys prefixes of the LevelDB storage:
+//
+//	b<block hash>          -> block
+//	h<block hash>          -> block header
+//	o<big endian sequence> -> block hash, to iterate blocks in the order they were put
+//	t<tx hash>             -> TX location
+//	a<account><position>   -> TX hash, the position is the big endian block number and index of the TX
+//	s                      -> accounts snapshot
+var (
+	levelDBBlockPrefix   = []byte("b")
+	levelDBHeaderPrefix  = []byte("h")
+	levelDBOrderPrefix   = []byte("o")
+	levelDBTxPrefix      = []byte("t")
+	levelDBAccountPrefix = []byte("a")
+	levelDBSnapshotKey   = []byte("s")
+)
+
+type levelDBStorage struct {
+	db *leveldb.DB
+	// Sequence of the next block put
+	nextSeq uint64
+}
+
+func openLevelDBStorage(path string) (*levelDBStorage, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &levelDBStorage{db: db}
+
+	iter := db.NewIterator(util.BytesPrefix(levelDBOrderPrefix), nil)
+	if iter.Last() {
+		storage.nextSeq = binary.BigEndian.Uint64(iter.Key()[len(levelDBOrderPrefix):]) + 1
+	}
+	iter.Release()
+
+	err = iter.Error()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+func levelDBKey(prefix []byte, key []byte) []byte {
+	return append(append([]byte{}, prefix...), key...)
+}
+
+func (l *levelDBStorage) PutBlock(blockHash Hash, b Block) error {
+	blockJson, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	headerJson, err := json.Marshal(b.Header)
+	if err != nil {
+		return err
+	}
+
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, l.nextSeq)
+
+	fmt.Printf("Persisting new Block to LevelDB:\n")
+	fmt.Printf("\t%s\n", blockJson)
+
+	batch := new(leveldb.Batch)
+	batch.Put(levelDBKey(levelDBBlockPrefix, blockHash[:]), blockJson)
+	batch.Put(levelDBKey(levelDBHeaderPrefix, blockHash[:]), headerJson)
+	batch.Put(levelDBKey(levelDBOrderPrefix, seq), blockHash[:])
+
+	err = l.db.Write(batch, nil)
+	if err != nil {
+		return err
+	}
+
+	l.nextSeq++
+
+	return nil
+}
+
+func (l *levelDBStorage) GetBlock(blockHash Hash) (Block, error) {
+	var b Block
+	err := l.get(levelDBKey(levelDBBlockPrefix, blockHash[:]), &b)
+	if err == leveldb.ErrNotFound {
+		return Block{}, fmt.Errorf("block '%s' not found", blockHash.Hex())
+	}
+
+	return b, err
+}
+
+func (l *levelDBStorage) GetHeader(blockHash Hash) (BlockHeader, error) {
+	var header BlockHeader
+	err := l.get(levelDBKey(levelDBHeaderPrefix, blockHash[:]), &header)
+	if err == leveldb.ErrNotFound {
+		return BlockHeader{}, fmt.Errorf("block '%s' not found", blockHash.Hex())
+	}
+
+	return header, err
+}
+
+func (l *levelDBStorage) BlockHashes() ([]Hash, error) {
+	hashes := make([]Hash, 0)
+
+	iter := l.db.NewIterator(util.BytesPrefix(levelDBOrderPrefix), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var hash Hash
+		copy(hash[:], iter.Value())
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, iter.Error()
+}
+
+func (l *levelDBStorage) PutTxLocation(txHash Hash, location TxLocation) error {
+	return l.put(levelDBKey(levelDBTxPrefix, txHash[:]), location)
+}
+
+func (l *levelDBStorage) DeleteTxLocation(txHash Hash) error {
+	return l.db.Delete(levelDBKey(levelDBTxPrefix, txHash[:]), nil)
+}
+
+func (l *levelDBStorage) GetTxLocation(txHash Hash) (TxLocation, error) {
+	var location TxLocation
+	err := l.get(levelDBKey(levelDBTxPrefix, txHash[:]), &location)
+	if err == leveldb.ErrNotFound {
+		return TxLocation{}, fmt.Errorf("TX '%s' not found", txHash.Hex())
+	}
+
+	return location, err
+}
+
+// accountTxKey orders the account history by position in the canonical chain.
+func accountTxKey(account common.Address, location TxLocation) []byte {
+	position := make([]byte, 16)
+	binary.BigEndian.PutUint64(position, location.BlockNumber)
+	binary.BigEndian.PutUint64(position[8:], uint64(location.Index))
+
+	return levelDBKey(levelDBAccountPrefix, append(account.Bytes(), position...))
+}
+
+func (l *levelDBStorage) PutAccountTx(account common.Address, txHash Hash, location TxLocation) error {
+	return l.db.Put(accountTxKey(account, location), txHash[:], nil)
+}
+
+func (l *levelDBStorage) DeleteAccountTx(account common.Address, txHash Hash, location TxLocation) error {
+	return l.db.Delete(accountTxKey(account, location), nil)
+}
+
+func (l *levelDBStorage) GetAccountTxs(account common.Address, offset int, limit int) ([]Hash, int, error) {
+	hashes := make([]Hash, 0)
+	total := 0
+
+	iter := l.db.NewIterator(util.BytesPrefix(levelDBKey(levelDBAccountPrefix, account.Bytes())), nil)
+	defer iter.Release()
+
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		if total >= offset && len(hashes) < limit {
+			var hash Hash
+			copy(hash[:], iter.Value())
+			hashes = append(hashes, hash)
+		}
+
+		total++
+	}
+
+	return hashes, total, iter.Error()
+}
+
+func (l *levelDBStorage) PutSnapshot(snapshot Snapshot) error {
+	return l.put(levelDBSnapshotKey, snapshot)
+}
+
+func (l *levelDBStorage) GetSnapshot() (Snapshot, error) {
+	var snapshot Snapshot
+	err := l.get(levelDBSnapshotKey, &snapshot)
+	if err == leveldb.ErrNotFound {
+		return Snapshot{}, nil
+	}
+
+	return snapshot, err
+}
+
+func (l *levelDBStorage) Close() error {
+	return l.db.Close()
+}
+
+func (l *levelDBStorage) put(key []byte, value interface{}) error {
+	valueJson, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return l.db.Put(key, valueJson, nil)
+}
+
+func (l *levelDBStorage) get(key []byte, value interface{}) error {
+	valueJson, err := l.db.Get(key, nil)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(valueJson, value)
+}
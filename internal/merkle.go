@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// MerkleProofStep is a sibling met on the way from a leaf up to the Merkle root.
+type MerkleProofStep struct {
+	Hash Hash `json:"hash"`
+	// Left is true when the sibling is hashed before the current node
+	Left bool `json:"left"`
+}
+
+// MerkleRoot returns the root of the Merkle tree built over the leaves, an empty hash without leaves.
+//
+// A node without sibling is promoted as is to the next level, instead of being paired with itself,
+// so two different lists of leaves never share a root.
+func MerkleRoot(leaves []Hash) Hash {
+	if len(leaves) == 0 {
+		return Hash{}
+	}
+
+	level := leaves
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+
+	return level[0]
+}
+
+// MerkleProof returns the siblings proving the leaf at the given index is part of the Merkle tree.
+func MerkleProof(leaves []Hash, index int) ([]MerkleProofStep, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("leaf index '%d' out of range, the tree has %d leaves", index, len(leaves))
+	}
+
+	proof := make([]MerkleProofStep, 0)
+
+	level := leaves
+	for len(level) > 1 {
+		if index%2 == 1 {
+			proof = append(proof, MerkleProofStep{level[index-1], true})
+		} else if index+1 < len(level) {
+			proof = append(proof, MerkleProofStep{level[index+1], false})
+		}
+
+		level = nextMerkleLevel(level)
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyMerkleProof checks the proof leads from the leaf to the root.
+func VerifyMerkleProof(root Hash, leaf Hash, proof []MerkleProofStep) bool {
+	node := leaf
+	for _, step := range proof {
+		if step.Left {
+			node = hashMerkleNodes(step.Hash, node)
+		} else {
+			node = hashMerkleNodes(node, step.Hash)
+		}
+	}
+
+	return node == root
+}
+
+// TxsMerkleRoot returns the Merkle root of the TXs hashes, in the block order.
+func TxsMerkleRoot(txs []SignedTx) (Hash, error) {
+	leaves, err := txsHashes(txs)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return MerkleRoot(leaves), nil
+}
+
+// TxProof returns the Merkle proof of a TX of the block against its header Merkle root.
+func (b Block) TxProof(txHash Hash) ([]MerkleProofStep, error) {
+	leaves, err := txsHashes(b.TXs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, leaf := range leaves {
+		if leaf == txHash {
+			return MerkleProof(leaves, i)
+		}
+	}
+
+	return nil, fmt.Errorf("TX '%s' not found in the block", txHash.Hex())
+}
+
+func validateMerkleRoot(b Block) error {
+	merkleRoot, err := TxsMerkleRoot(b.TXs)
+	if err != nil {
+		return err
+	}
+
+	if merkleRoot != b.Header.MerkleRoot {
+		return fmt.Errorf("block Merkle root must be '%s' not '%s'", merkleRoot.Hex(), b.Header.MerkleRoot.Hex())
+	}
+
+	return nil
+}
+
+func txsHashes(txs []SignedTx) ([]Hash, error) {
+	hashes := make([]Hash, len(txs))
+	for i, tx := range txs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			return nil, err
+		}
+
+		hashes[i] = txHash
+	}
+
+	return hashes, nil
+}
+
+func nextMerkleLevel(level []Hash) []Hash {
+	next := make([]Hash, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+
+		next = append(next, hashMerkleNodes(level[i], level[i+1]))
+	}
+
+	return next
+}
+
+// hashMerkleNodes prefixes the pair so an inner node can't be passed off as a TX hash.
+func hashMerkleNodes(left, right Hash) Hash {
+	data := make([]byte, 0, 1+2*len(left))
+	data = append(data, 0x01)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+
+	return sha256.Sum256(data)
+}
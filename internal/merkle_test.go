@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for count := 1; count <= 7; count++ {
+		leaves := make([]Hash, count)
+		for i := range leaves {
+			leaves[i] = sha256.Sum256([]byte(fmt.Sprintf("tx %d", i)))
+		}
+
+		root := MerkleRoot(leaves)
+
+		for i, leaf := range leaves {
+			proof, err := MerkleProof(leaves, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !VerifyMerkleProof(root, leaf, proof) {
+				t.Fatalf("proof of leaf %d out of %d should be valid", i, count)
+			}
+
+			if count > 1 && VerifyMerkleProof(root, leaves[(i+1)%count], proof) {
+				t.Fatalf("proof of leaf %d out of %d should not be valid for another leaf", i, count)
+			}
+		}
+	}
+}
+
+func TestMerkleRoot_OddLeaves(t *testing.T) {
+	a := sha256.Sum256([]byte("a"))
+	b := sha256.Sum256([]byte("b"))
+	c := sha256.Sum256([]byte("c"))
+
+	// Pairing the last leaf with itself would give both lists the same root
+	if MerkleRoot([]Hash{a, b, c}) == MerkleRoot([]Hash{a, b, c, c}) {
+		t.Fatal("duplicating the last leaf should change the root")
+	}
+
+	if !MerkleRoot(nil).IsEmpty() {
+		t.Fatal("the root of no leaves should be empty")
+	}
+}
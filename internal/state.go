@@ -1,24 +1,45 @@
 package internal
 
 import (
-	"bufio"
-	"encoding/json"
 	"fmt"
-	"os"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
+// State is safe for concurrent use, its exported methods take the state lock.
+//
+// Balances and Account2Nonce are replaced, never modified, by each canonical chain change.
+// They must be read through GetBalance and GetNextAccountNonce while the state is shared.
 type State struct {
+	mu sync.RWMutex
+
 	Balances      map[common.Address]uint
 	Account2Nonce map[common.Address]uint
 
-	dbFile *os.File
+	storage Storage
 
 	latestBlock     Block
 	latestBlockHash Hash
 	hasGenesisBlock bool
+
+	// Headers of every known block, including the ones on side branches
+	headers map[Hash]BlockHeader
+	// Sum of the difficulties from the first block up to the given one
+	totalDifficulty map[Hash]uint64
+	// Hashes of the canonical chain indexed by block number
+	chain []Hash
+
+	chainID string
+	// genesisHash identifies the network along with chainID, see Genesis.Hash
+	genesisHash Hash
+	consensus   ConsensusParams
+
+	reorgHandler func(orphaned, adopted []Block)
+	// Reorganizations not reported to the handler yet
+	reorgEvents []reorgEvent
 }
 
 func NewStateFromDisk(dataDir string) (*State, error) {
@@ -32,6 +53,11 @@ func NewStateFromDisk(dataDir string) (*State, error) {
 		return nil, err
 	}
 
+	genesisHash, err := gen.Hash()
+	if err != nil {
+		return nil, err
+	}
+
 	balances := make(map[common.Address]uint)
 	for account, balance := range gen.Balances {
 		balances[account] = balance
@@ -39,44 +65,131 @@ func NewStateFromDisk(dataDir string) (*State, error) {
 
 	account2nonce := make(map[common.Address]uint)
 
-	dbFilepath := getBlocksDbFilePath(dataDir)
-	f, err := os.OpenFile(dbFilepath, os.O_APPEND|os.O_RDWR, 0600)
+	storage, err := openStorage(dataDir)
 	if err != nil {
 		return nil, err
 	}
 
-	scanner := bufio.NewScanner(f)
+	state := &State{
+		Balances:        balances,
+		Account2Nonce:   account2nonce,
+		storage:         storage,
+		headers:         make(map[Hash]BlockHeader),
+		totalDifficulty: make(map[Hash]uint64),
+		chain:           make([]Hash, 0),
+		chainID:         gen.ChainID,
+		genesisHash:     genesisHash,
+		consensus:       gen.Consensus.withDefaults(),
+	}
 
-	state := &State{balances, account2nonce, f, Block{}, Hash{}, false}
+	err = state.load()
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
 
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
+	return state, nil
+}
 
-		blockFsJson := scanner.Bytes()
+// load restores the latest accounts snapshot and replays the blocks stored after it.
+//
+// Blocks in storage were already accepted once, replaying them rebuilds
+// the balances, the canonical chain and the side branches.
+func (s *State) load() error {
+	persistedBlocks, err := s.storage.BlockHashes()
+	if err != nil {
+		return err
+	}
 
-		if len(blockFsJson) == 0 {
-			break
+	replayFrom, err := s.loadSnapshot(persistedBlocks)
+	if err != nil {
+		return err
+	}
+
+	for _, blockHash := range persistedBlocks[replayFrom:] {
+		b, err := s.storage.GetBlock(blockHash)
+		if err != nil {
+			return err
 		}
 
-		var blockFs BlockFS
-		err = json.Unmarshal(blockFsJson, &blockFs)
+		_, err = s.addBlock(b, false)
 		if err != nil {
-			return nil, err
+			return err
 		}
+	}
+
+	if replayFrom == len(persistedBlocks) || !s.hasGenesisBlock {
+		return nil
+	}
 
-		err = applyTXs(blockFs.Value.TXs, state)
+	return s.storage.PutSnapshot(s.snapshot())
+}
+
+// loadSnapshot restores the accounts and the canonical chain from the latest snapshot.
+//
+// It returns how many of the persisted blocks are covered by the snapshot.
+func (s *State) loadSnapshot(persistedBlocks []Hash) (int, error) {
+	snapshot, err := s.storage.GetSnapshot()
+	if err != nil {
+		return 0, err
+	}
+
+	covered := 0
+	for i, blockHash := range persistedBlocks {
+		if blockHash == snapshot.BlockHash {
+			covered = i + 1
+			break
+		}
+	}
+
+	if snapshot.BlockHash.IsEmpty() || covered == 0 || snapshot.Version != snapshotVersion {
+		return 0, nil
+	}
+
+	for _, blockHash := range persistedBlocks[:covered] {
+		header, err := s.storage.GetHeader(blockHash)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
 
-		state.latestBlock = blockFs.Value
-		state.latestBlockHash = blockFs.Key
-		state.hasGenesisBlock = true
+		s.storeHeader(blockHash, header)
 	}
 
-	return state, nil
+	latestBlock, err := s.storage.GetBlock(snapshot.BlockHash)
+	if err != nil {
+		return 0, err
+	}
+
+	chain := make([]Hash, latestBlock.Header.Number+1)
+	for hash := snapshot.BlockHash; !hash.IsEmpty(); hash = s.headers[hash].Parent {
+		chain[s.headers[hash].Number] = hash
+	}
+
+	s.Balances = snapshot.Balances
+	s.Account2Nonce = snapshot.Account2Nonce
+	s.latestBlock = latestBlock
+	s.latestBlockHash = snapshot.BlockHash
+	s.hasGenesisBlock = true
+	s.chain = chain
+
+	return covered, nil
+}
+
+func (s *State) snapshot() Snapshot {
+	return Snapshot{snapshotVersion, s.latestBlockHash, s.Balances, s.Account2Nonce}
+}
+
+// SetReorgHandler registers a callback invoked whenever the canonical chain switches to another branch.
+//
+// The orphaned blocks are the ones removed from the canonical chain and the adopted ones
+// are their replacements, both ordered by block number.
+//
+// The handler is invoked once the state lock is released, it can query the state.
+func (s *State) SetReorgHandler(handler func(orphaned, adopted []Block)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reorgHandler = handler
 }
 
 func (s *State) AddBlocks(blocks []Block) error {
@@ -90,42 +203,302 @@ func (s *State) AddBlocks(blocks []Block) error {
 	return nil
 }
 
+// AddBlock adds a block either on top of the canonical chain or to a side branch.
+//
+// When a side branch becomes heavier than the canonical chain the state is reorganized on top of it.
 func (s *State) AddBlock(b Block) (Hash, error) {
-	pendingState := s.copy()
+	s.mu.Lock()
+	blockHash, err := s.addBlock(b, true)
+	handler := s.reorgHandler
+	reorgEvents := s.reorgEvents
+	s.reorgEvents = nil
+	s.mu.Unlock()
+
+	for _, event := range reorgEvents {
+		handler(event.orphaned, event.adopted)
+	}
+
+	return blockHash, err
+}
 
-	err := applyBlock(b, &pendingState)
+func (s *State) addBlock(b Block, persist bool) (Hash, error) {
+	blockHash, err := b.Hash()
 	if err != nil {
 		return Hash{}, err
 	}
 
-	blockHash, err := b.Hash()
+	if s.hasBlock(blockHash) {
+		return blockHash, nil
+	}
+
+	err = s.validateBlockHeader(b, blockHash)
 	if err != nil {
 		return Hash{}, err
 	}
 
-	blockFs := BlockFS{blockHash, b}
-
-	blockFsJson, err := json.Marshal(blockFs)
+	// The hash only covers the header, a block with other TXs must not be stored under it
+	err = validateMerkleRoot(b)
 	if err != nil {
 		return Hash{}, err
 	}
 
-	fmt.Printf("Persisting new Block to disk:\n")
-	fmt.Printf("\t%s\n", blockFsJson)
+	extendsChain := b.Header.Parent == s.latestBlockHash
+	if !s.hasGenesisBlock {
+		extendsChain = b.Header.Parent.IsEmpty()
+	}
 
-	_, err = s.dbFile.Write(append(blockFsJson, '\n'))
+	if !extendsChain && !s.isBetterBranch(b) {
+		if persist {
+			err = s.storage.PutBlock(blockHash, b)
+			if err != nil {
+				return Hash{}, err
+			}
+		}
+
+		fmt.Printf("Stored Block '%s' on a side branch at height %d\n", blockHash.Hex(), b.Header.Number)
+		s.storeHeader(blockHash, b.Header)
+
+		return blockHash, nil
+	}
+
+	if extendsChain {
+		pendingState := s.copy()
+
+		err = applyBlock(b, pendingState)
+		if err != nil {
+			return Hash{}, err
+		}
+
+		err = s.persistCanonicalChange(b, blockHash, pendingState, nil, []Block{b}, []Hash{blockHash}, persist)
+		if err != nil {
+			return Hash{}, err
+		}
+
+		s.storeHeader(blockHash, b.Header)
+		s.commit(pendingState, append(s.chain, blockHash))
+
+		return blockHash, nil
+	}
+
+	err = s.reorg(b, blockHash, persist)
 	if err != nil {
 		return Hash{}, err
 	}
 
-	s.Balances = pendingState.Balances
-	s.latestBlockHash = blockHash
-	s.latestBlock = b
-	s.hasGenesisBlock = true
-
 	return blockHash, nil
 }
 
+// validateBlockHeader checks the block can be attached to a known parent, on any branch,
+// and satisfies the proof-of-work required at this position.
+func (s *State) validateBlockHeader(b Block, blockHash Hash) error {
+	return s.validateHeader(b.Header, blockHash, s.getHeader)
+}
+
+// headerLookup returns a known block header by the block hash.
+type headerLookup func(hash Hash) (BlockHeader, bool)
+
+func (s *State) getHeader(hash Hash) (BlockHeader, bool) {
+	header, ok := s.headers[hash]
+
+	return header, ok
+}
+
+// validateHeader checks the header follows its parent, found through getHeader, and satisfies its proof-of-work.
+func (s *State) validateHeader(header BlockHeader, blockHash Hash, getHeader headerLookup) error {
+	expectedDifficulty := s.consensus.Difficulty
+
+	maxTime := uint64(time.Now().Unix()) + MaxBlockTimeDrift
+	if header.Time > maxTime {
+		return fmt.Errorf("block time '%d' is more than %d seconds in the future", header.Time, MaxBlockTimeDrift)
+	}
+
+	if header.Parent.IsEmpty() {
+		if header.Number != 0 {
+			return fmt.Errorf("block without parent must be '0' not '%d'", header.Number)
+		}
+	} else {
+		parent, ok := getHeader(header.Parent)
+		if !ok {
+			return fmt.Errorf("unknown parent block '%s'", header.Parent.Hex())
+		}
+
+		if header.Number != parent.Number+1 {
+			return fmt.Errorf("next expected block must be '%d' not '%d'", parent.Number+1, header.Number)
+		}
+
+		if header.Time < parent.Time {
+			return fmt.Errorf("block time '%d' is before its parent time '%d'", header.Time, parent.Time)
+		}
+
+		expectedDifficulty = s.nextDifficultyOn(parent, getHeader)
+	}
+
+	if header.Difficulty != expectedDifficulty {
+		return fmt.Errorf("block difficulty must be '%d' not '%d'", expectedDifficulty, header.Difficulty)
+	}
+
+	if !IsBlockHashValid(blockHash, header.Difficulty) {
+		return fmt.Errorf("invalid block hash %x", blockHash)
+	}
+
+	return nil
+}
+
+// ValidateHeaders checks a chain of headers downloaded ahead of their blocks, as the headers of AddBlock.
+//
+// The first header follows a known block, or the last of the ancestors: headers validated earlier whose blocks are not added yet.
+func (s *State) ValidateHeaders(ancestors []BlockHeaderFS, headers []BlockHeaderFS) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pending := make(map[Hash]BlockHeader, len(ancestors)+len(headers))
+	for _, ancestor := range ancestors {
+		pending[ancestor.Key] = ancestor.Value
+	}
+
+	getHeader := func(hash Hash) (BlockHeader, bool) {
+		if header, ok := pending[hash]; ok {
+			return header, true
+		}
+
+		return s.getHeader(hash)
+	}
+
+	for i, header := range headers {
+		blockHash, err := header.Value.Hash()
+		if err != nil {
+			return err
+		}
+
+		if blockHash != header.Key {
+			return fmt.Errorf("header hash '%s' doesn't match its content", header.Key.Hex())
+		}
+
+		// The chain must not branch out in the middle of the list
+		if i > 0 && header.Value.Parent != headers[i-1].Key {
+			return fmt.Errorf("header '%s' doesn't follow header '%s'", header.Key.Hex(), headers[i-1].Key.Hex())
+		}
+
+		err = s.validateHeader(header.Value, blockHash, getHeader)
+		if err != nil {
+			return err
+		}
+
+		pending[blockHash] = header.Value
+	}
+
+	return nil
+}
+
+func (s *State) storeHeader(blockHash Hash, header BlockHeader) {
+	s.headers[blockHash] = header
+	s.totalDifficulty[blockHash] = s.totalDifficulty[header.Parent] + header.Difficulty
+}
+
+// persistCanonicalChange stores the new block and the data derived from the new canonical chain.
+//
+// The locations of the TXs in adopted blocks are recorded and the ones only found in orphaned blocks removed.
+// The accounts history follows the same TXs.
+// When replaying already persisted blocks only the TXs indexes are updated.
+func (s *State) persistCanonicalChange(b Block, blockHash Hash, pendingState *State, orphaned, adopted []Block, adoptedHashes []Hash, persist bool) error {
+	if persist {
+		err := s.storage.PutBlock(blockHash, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Orphaned TXs are removed from the accounts history first, adopted blocks may hold them at another position
+	for _, orphanedBlock := range orphaned {
+		orphanedHash, err := orphanedBlock.Hash()
+		if err != nil {
+			return err
+		}
+
+		for j, tx := range orphanedBlock.TXs {
+			txHash, err := tx.Hash()
+			if err != nil {
+				return err
+			}
+
+			for _, account := range txAccounts(tx) {
+				err = s.storage.DeleteAccountTx(account, txHash, TxLocation{orphanedHash, orphanedBlock.Header.Number, j})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	adoptedTXs := make(map[Hash]bool)
+	for i, adoptedBlock := range adopted {
+		for j, tx := range adoptedBlock.TXs {
+			txHash, err := tx.Hash()
+			if err != nil {
+				return err
+			}
+
+			location := TxLocation{adoptedHashes[i], adoptedBlock.Header.Number, j}
+
+			err = s.storage.PutTxLocation(txHash, location)
+			if err != nil {
+				return err
+			}
+
+			for _, account := range txAccounts(tx) {
+				err = s.storage.PutAccountTx(account, txHash, location)
+				if err != nil {
+					return err
+				}
+			}
+
+			adoptedTXs[txHash] = true
+		}
+	}
+
+	for _, orphanedBlock := range orphaned {
+		for _, tx := range orphanedBlock.TXs {
+			txHash, err := tx.Hash()
+			if err != nil {
+				return err
+			}
+
+			if adoptedTXs[txHash] {
+				continue
+			}
+
+			err = s.storage.DeleteTxLocation(txHash)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !persist {
+		return nil
+	}
+
+	return s.storage.PutSnapshot(pendingState.snapshot())
+}
+
+// txAccounts returns the accounts whose history contains the TX.
+func txAccounts(tx SignedTx) []common.Address {
+	if tx.From == tx.To {
+		return []common.Address{tx.From}
+	}
+
+	return []common.Address{tx.From, tx.To}
+}
+
+func (s *State) commit(pendingState *State, chain []Hash) {
+	s.Balances = pendingState.Balances
+	s.Account2Nonce = pendingState.Account2Nonce
+	s.latestBlock = pendingState.latestBlock
+	s.latestBlockHash = pendingState.latestBlockHash
+	s.hasGenesisBlock = pendingState.hasGenesisBlock
+	s.chain = chain
+}
+
 // applyBlock verifies if block can be added to the blockchain.
 //
 // Block metadata are verified as well as transactions within (sufficient balances, etc).
@@ -136,7 +509,7 @@ func applyBlock(b Block, s *State) error {
 		return fmt.Errorf("next expected block must be '%d' not '%d'", nextExpectedBlockNumber, b.Header.Number)
 	}
 
-	if s.hasGenesisBlock && s.latestBlock.Header.Number > 0 && !reflect.DeepEqual(b.Header.Parent, s.latestBlockHash) {
+	if s.hasGenesisBlock && !reflect.DeepEqual(b.Header.Parent, s.latestBlockHash) {
 		return fmt.Errorf("next block parent hash must be '%x' not '%x'", s.latestBlockHash, b.Header.Parent)
 	}
 
@@ -145,16 +518,30 @@ func applyBlock(b Block, s *State) error {
 		return err
 	}
 
-	if !IsBlockHashValid(hash) {
+	expectedDifficulty := s.nextBlockDifficulty()
+	if b.Header.Difficulty != expectedDifficulty {
+		return fmt.Errorf("block difficulty must be '%d' not '%d'", expectedDifficulty, b.Header.Difficulty)
+	}
+
+	if !IsBlockHashValid(hash, b.Header.Difficulty) {
 		return fmt.Errorf("invalid block hash %x", hash)
 	}
 
+	err = validateMerkleRoot(b)
+	if err != nil {
+		return err
+	}
+
 	err = applyTXs(b.TXs, s)
 	if err != nil {
 		return err
 	}
 
-	s.Balances[b.Header.Miner] += BlockReward
+	s.Balances[b.Header.Miner] += BlockReward + b.Fees()
+
+	s.latestBlock = b
+	s.latestBlockHash = hash
+	s.hasGenesisBlock = true
 
 	return nil
 }
@@ -180,16 +567,25 @@ func applyTx(tx SignedTx, s *State) error {
 		return fmt.Errorf("wrong TX. Sender '%s' is forged", tx.From.String())
 	}
 
-	expectedNonce := s.GetNextAccountNonce(tx.From)
+	if tx.ChainID != s.chainID {
+		return fmt.Errorf("wrong TX. Signed for chain '%s', not '%s'", tx.ChainID, s.chainID)
+	}
+
+	expectedNonce := s.nextAccountNonce(tx.From)
 	if tx.Nonce != expectedNonce {
 		return fmt.Errorf("wrong TX. Sender '%s' next nonce must be '%d', not '%d'", tx.From.String(), expectedNonce, tx.Nonce)
 	}
 
-	if tx.Value > s.Balances[tx.From] {
-		return fmt.Errorf("wrong TX. Sender '%s' balance is %d TBB. Tx cost is %d TBB", tx.From.String(), s.Balances[tx.From], tx.Value)
+	err = tx.ValidateFee()
+	if err != nil {
+		return err
 	}
 
-	s.Balances[tx.From] -= tx.Value
+	if tx.Cost() > s.Balances[tx.From] {
+		return fmt.Errorf("wrong TX. Sender '%s' balance is %d TBB. Tx cost is %d TBB", tx.From.String(), s.Balances[tx.From], tx.Cost())
+	}
+
+	s.Balances[tx.From] -= tx.Cost()
 	s.Balances[tx.To] += tx.Value
 
 	s.Account2Nonce[tx.From] = tx.Nonce
@@ -197,31 +593,130 @@ func applyTx(tx SignedTx, s *State) error {
 	return nil
 }
 
+// SelectValidTXs simulates the TXs, in the given order, on a copy of the latest state
+// and returns the ones a block on top of the canonical chain can include.
+//
+// The reasons the other TXs are invalid are returned by TX hash. The TXs following
+// an invalid TX of the same sender are left out without being reported, their nonce would be off.
+func (s *State) SelectValidTXs(txs []SignedTx) ([]SignedTx, map[Hash]error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pendingState := s.copy()
+	valid := make([]SignedTx, 0, len(txs))
+	invalid := make(map[Hash]error)
+	blockedSenders := make(map[common.Address]bool)
+
+	for _, tx := range txs {
+		if blockedSenders[tx.From] {
+			continue
+		}
+
+		err := applyTx(tx, pendingState)
+		if err != nil {
+			txHash, hashErr := tx.Hash()
+			if hashErr != nil {
+				err = hashErr
+			}
+
+			invalid[txHash] = err
+			blockedSenders[tx.From] = true
+			continue
+		}
+
+		valid = append(valid, tx)
+	}
+
+	return valid, invalid
+}
+
 func (s *State) Close() {
-	s.dbFile.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.storage.Close()
 }
 
 func (s *State) NextBlockNumber() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if !s.hasGenesisBlock {
 		return uint64(0)
 	}
 
-	return s.LatestBlock().Header.Number + 1
+	return s.latestBlock.Header.Number + 1
 }
 
 func (s *State) LatestBlockHash() Hash {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return s.latestBlockHash
 }
 
 func (s *State) LatestBlock() Block {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return s.latestBlock
 }
 
-func (s *State) copy() State {
-	c := State{}
+// TotalDifficulty is the sum of the difficulties of the canonical chain blocks, the weight compared by the fork choice.
+func (s *State) TotalDifficulty() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.totalDifficulty[s.latestBlockHash]
+}
+
+func (s *State) HasBlock(hash Hash) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.hasBlock(hash)
+}
+
+func (s *State) hasBlock(hash Hash) bool {
+	_, ok := s.headers[hash]
+
+	return ok
+}
+
+func (s *State) GetBalance(account common.Address) uint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.Balances[account]
+}
+
+// GetBalances returns a copy of the balances and the hash of the latest block they include.
+func (s *State) GetBalances() (Hash, map[common.Address]uint) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	balances := make(map[common.Address]uint, len(s.Balances))
+	for account, balance := range s.Balances {
+		balances[account] = balance
+	}
+
+	return s.latestBlockHash, balances
+}
+
+// copy returns a state to apply changes to without affecting this one.
+//
+// The block headers and the storage are shared, the accounts are copied.
+func (s *State) copy() *State {
+	c := &State{}
 	c.hasGenesisBlock = s.hasGenesisBlock
 	c.latestBlock = s.latestBlock
 	c.latestBlockHash = s.latestBlockHash
+	c.storage = s.storage
+	c.headers = s.headers
+	c.totalDifficulty = s.totalDifficulty
+	c.chainID = s.chainID
+	c.genesisHash = s.genesisHash
+	c.consensus = s.consensus
 	c.Balances = make(map[common.Address]uint)
 	c.Account2Nonce = make(map[common.Address]uint)
 
@@ -236,6 +731,23 @@ func (s *State) copy() State {
 	return c
 }
 
+// ChainID identifies the network of the state, TXs are only valid on the chain they were signed for.
+func (s *State) ChainID() string {
+	return s.chainID
+}
+
+// GenesisHash identifies the genesis the state was created from, nodes only sync with nodes of the same genesis.
+func (s *State) GenesisHash() Hash {
+	return s.genesisHash
+}
+
 func (s *State) GetNextAccountNonce(account common.Address) uint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.nextAccountNonce(account)
+}
+
+func (s *State) nextAccountNonce(account common.Address) uint {
 	return s.Account2Nonce[account] + 1
 }
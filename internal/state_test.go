@@ -0,0 +1,568 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestState_ReorgToHeavierBranch(t *testing.T) {
+	state, privKey, sender := newTestState(t)
+	receiver := NewAccount("0x0000000000000000000000000000000000000001")
+	minerA := NewAccount("0x00000000000000000000000000000000000000aa")
+	minerB := NewAccount("0x00000000000000000000000000000000000000bb")
+
+	var orphaned, adopted []Block
+	state.SetReorgHandler(func(o, a []Block) {
+		orphaned, adopted = o, a
+	})
+
+	block0 := NewBlock(Hash{}, 1, 0, 0, 1, minerA, []SignedTx{signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 10, 1, ""), privKey)})
+	block0Hash := addTestBlock(t, state, block0)
+
+	blockA1 := NewBlock(block0Hash, 2, 1, 0, 1, minerA, []SignedTx{signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 20, 2, ""), privKey)})
+	blockA1Hash := addTestBlock(t, state, blockA1)
+
+	// Same weight as the canonical chain, the first seen branch is kept
+	blockB1 := NewBlock(block0Hash, 3, 1, 0, 1, minerB, []SignedTx{signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 5, 2, ""), privKey)})
+	blockB1Hash := addTestBlock(t, state, blockB1)
+
+	if state.LatestBlockHash() != blockA1Hash {
+		t.Fatal("a side branch of equal weight must not replace the canonical chain")
+	}
+
+	blockB2 := NewBlock(blockB1Hash, 4, 2, 0, 1, minerB, nil)
+	blockB2Hash := addTestBlock(t, state, blockB2)
+
+	if state.LatestBlockHash() != blockB2Hash {
+		t.Fatal("the heavier branch should have become the canonical chain")
+	}
+
+	if state.Balances[sender] != 1000-10-5-2*TxFee {
+		t.Fatalf("sender balance should be %d not %d", 1000-10-5-2*TxFee, state.Balances[sender])
+	}
+
+	if state.Balances[receiver] != 15 {
+		t.Fatalf("receiver balance should be %d not %d", 15, state.Balances[receiver])
+	}
+
+	if state.Balances[minerA] != BlockReward+TxFee || state.Balances[minerB] != 2*BlockReward+TxFee {
+		t.Fatal("block reward and fees of the orphaned block should have been reverted")
+	}
+
+	if state.GetNextAccountNonce(sender) != 3 {
+		t.Fatalf("sender next nonce should be 3 not %d", state.GetNextAccountNonce(sender))
+	}
+
+	if len(orphaned) != 1 || len(adopted) != 2 {
+		t.Fatalf("expected 1 orphaned and 2 adopted blocks, got %d and %d", len(orphaned), len(adopted))
+	}
+
+	blocks, err := state.GetBlocksAfter(block0Hash, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blocks) != 2 || blocks[0].Header.Miner != minerB {
+		t.Fatal("blocks after the fork point should come from the new canonical chain")
+	}
+}
+
+func TestState_RejectTamperedTXs(t *testing.T) {
+	state, privKey, sender := newTestState(t)
+	receiver := NewAccount("0x0000000000000000000000000000000000000001")
+
+	tx := signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 10, 1, ""), privKey)
+	block0 := NewBlock(Hash{}, 1, 0, 0, 1, sender, []SignedTx{tx})
+
+	// The header, and thus the hash, stays the same
+	tampered := block0
+	tampered.TXs = []SignedTx{signTestTx(t, NewTx(sender, sender, TxGas, TxGasPriceDefault, 10, 1, ""), privKey)}
+
+	_, err := state.AddBlock(tampered)
+	if err == nil {
+		t.Fatal("block with TXs not matching its Merkle root should be rejected")
+	}
+
+	block0Hash := addTestBlock(t, state, block0)
+
+	blockHash, header, proof, err := state.GetTxProof(mustHashTx(t, tx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blockHash != block0Hash || !VerifyMerkleProof(header.MerkleRoot, mustHashTx(t, tx), proof) {
+		t.Fatal("TX should be proven part of the block")
+	}
+}
+
+func TestState_RejectOverflowingTXCost(t *testing.T) {
+	state, privKey, sender := newTestState(t)
+	receiver := NewAccount("0x0000000000000000000000000000000000000001")
+	maxUint := ^uint(0)
+
+	tests := []struct {
+		name string
+		tx   Tx
+	}{
+		{"value and fee overflow the cost", NewTx(sender, receiver, TxGas, TxGasPriceDefault, maxUint-9, 1, "")},
+		{"gas price overflows the fee", NewTx(sender, receiver, TxGas, maxUint/10, 1, 1, "")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := signTestTx(t, test.tx, privKey)
+
+			_, invalidTXs := state.SelectValidTXs([]SignedTx{tx})
+			if len(invalidTXs) != 1 {
+				t.Fatal("an overflowing TX should not be selected")
+			}
+
+			_, err := state.AddBlock(NewBlock(Hash{}, 1, 0, 0, 1, sender, []SignedTx{tx}))
+			if err == nil {
+				t.Fatal("an overflowing TX should be rejected")
+			}
+
+			if state.GetBalance(sender) != 1000 || state.GetBalance(receiver) != 0 {
+				t.Fatal("balances must not change")
+			}
+		})
+	}
+}
+
+func TestState_RejectOtherChainTX(t *testing.T) {
+	state, privKey, sender := newTestState(t)
+	receiver := NewAccount("0x0000000000000000000000000000000000000001")
+
+	tx := NewTx(sender, receiver, TxGas, TxGasPriceDefault, 10, 1, "")
+	tx.ChainID = "another-chain"
+
+	rawTx, err := tx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txHash := sha256.Sum256(rawTx)
+	sig, err := crypto.Sign(txHash[:], privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = state.AddBlock(NewBlock(Hash{}, 1, 0, 0, 1, sender, []SignedTx{NewSignedTx(tx, sig)}))
+	if err == nil {
+		t.Fatal("TX signed for another chain should be rejected")
+	}
+}
+
+func TestState_RejectInvalidBranch(t *testing.T) {
+	state, privKey, sender := newTestState(t)
+	receiver := NewAccount("0x0000000000000000000000000000000000000001")
+
+	block0 := NewBlock(Hash{}, 1, 0, 0, 1, sender, nil)
+	block0Hash := addTestBlock(t, state, block0)
+
+	blockA1 := NewBlock(block0Hash, 2, 1, 0, 1, sender, nil)
+	blockA1Hash := addTestBlock(t, state, blockA1)
+
+	// Spends more than the sender owns, only detected once the branch gets applied
+	overspend := signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 5000, 1, ""), privKey)
+	blockB1 := NewBlock(block0Hash, 3, 1, 0, 1, sender, []SignedTx{overspend})
+	blockB1Hash := addTestBlock(t, state, blockB1)
+
+	blockB2 := NewBlock(blockB1Hash, 4, 2, 0, 1, sender, nil)
+	_, err := state.AddBlock(blockB2)
+	if err == nil {
+		t.Fatal("a branch containing an invalid TX must be rejected")
+	}
+
+	if state.LatestBlockHash() != blockA1Hash {
+		t.Fatal("the canonical chain must be kept when the heavier branch is invalid")
+	}
+
+	if state.Balances[sender] != 1000+2*BlockReward {
+		t.Fatalf("sender balance should be %d not %d", 1000+2*BlockReward, state.Balances[sender])
+	}
+}
+
+func TestState_LoadFromDisk(t *testing.T) {
+	for _, backend := range []string{FlatFileStorage, LevelDBStorage} {
+		t.Run(backend, func(t *testing.T) {
+			dataDir, privKey, sender := newTestDataDir(t)
+			receiver := NewAccount("0x0000000000000000000000000000000000000001")
+
+			err := InitStorage(dataDir, backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			state := loadTestState(t, dataDir)
+
+			tx := signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 10, 1, ""), privKey)
+			block0 := NewBlock(Hash{}, 1, 0, 0, 1, sender, []SignedTx{tx})
+			block0Hash := addTestBlock(t, state, block0)
+
+			blockA1 := NewBlock(block0Hash, 2, 1, 0, 1, sender, nil)
+			addTestBlock(t, state, blockA1)
+
+			blockB1 := NewBlock(block0Hash, 3, 1, 0, 1, receiver, nil)
+			blockB1Hash := addTestBlock(t, state, blockB1)
+
+			blockB2 := NewBlock(blockB1Hash, 4, 2, 0, 1, receiver, nil)
+			blockB2Hash := addTestBlock(t, state, blockB2)
+
+			state.Close()
+
+			// From the snapshot, then replaying every block without it
+			for _, removeSnapshot := range []bool{false, true} {
+				if removeSnapshot && backend == FlatFileStorage {
+					err := os.Remove(getSnapshotFilePath(dataDir))
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				loaded := loadTestState(t, dataDir)
+
+				if loaded.LatestBlockHash() != blockB2Hash {
+					t.Fatal("the reloaded canonical chain should end with the heaviest branch")
+				}
+
+				if loaded.Balances[receiver] != 10+2*BlockReward || loaded.GetNextAccountNonce(sender) != 2 {
+					t.Fatal("the reloaded balances and nonces should match the canonical chain")
+				}
+
+				b, err := loaded.GetBlockByNumber(1)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if b.Header.Miner != receiver {
+					t.Fatal("block number 1 should be looked up on the canonical chain")
+				}
+
+				txHash, _ := tx.Hash()
+				location, err := loaded.GetTxLocation(txHash)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if location.BlockHash != block0Hash || location.Index != 0 {
+					t.Fatal("the TX should be located in the first block")
+				}
+
+				loaded.Close()
+			}
+		})
+	}
+}
+
+func TestState_AccountTxsFollowCanonicalChain(t *testing.T) {
+	for _, backend := range []string{FlatFileStorage, LevelDBStorage} {
+		t.Run(backend, func(t *testing.T) {
+			dataDir, privKey, sender := newTestDataDir(t)
+			receiver := NewAccount("0x0000000000000000000000000000000000000001")
+
+			err := InitStorage(dataDir, backend)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			state := loadTestState(t, dataDir)
+
+			tx1 := signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 10, 1, ""), privKey)
+			block0Hash := addTestBlock(t, state, NewBlock(Hash{}, 1, 0, 0, 1, sender, []SignedTx{tx1}))
+
+			tx2 := signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 20, 2, ""), privKey)
+			addTestBlock(t, state, NewBlock(block0Hash, 2, 1, 0, 1, sender, []SignedTx{tx2}))
+
+			tx3 := signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 5, 2, ""), privKey)
+			blockB1Hash := addTestBlock(t, state, NewBlock(block0Hash, 3, 1, 0, 1, sender, []SignedTx{tx3}))
+			addTestBlock(t, state, NewBlock(blockB1Hash, 4, 2, 0, 1, sender, nil))
+
+			assertAccountTxs := func(state *State) {
+				for _, account := range []common.Address{sender, receiver} {
+					txs, total, err := state.GetAccountTxs(account, 0, 10)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if total != 2 || len(txs) != 2 || txs[0].Hash != mustHashTx(t, tx3) || txs[1].Hash != mustHashTx(t, tx1) {
+						t.Fatal("the account history should only hold the canonical chain TXs, the latest first")
+					}
+
+					if txs[0].Location.BlockHash != blockB1Hash || txs[0].Location.BlockNumber != 1 {
+						t.Fatal("the TX should be located in the adopted block")
+					}
+
+					page, total, err := state.GetAccountTxs(account, 1, 1)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if total != 2 || len(page) != 1 || page[0].Hash != mustHashTx(t, tx1) {
+						t.Fatal("the second page should hold the oldest TX")
+					}
+				}
+
+				_, err := state.GetTx(mustHashTx(t, tx2))
+				if err == nil {
+					t.Fatal("the orphaned TX should not be found")
+				}
+			}
+
+			assertAccountTxs(state)
+			state.Close()
+
+			assertAccountTxs(loadTestState(t, dataDir))
+		})
+	}
+}
+
+func TestState_RejectBlockFromTheFuture(t *testing.T) {
+	state, _, sender := newTestState(t)
+
+	block0Hash := addTestBlock(t, state, NewBlock(Hash{}, 1, 0, 0, 1, sender, nil))
+
+	// Far enough ahead to ease the next retarget
+	future := uint64(time.Now().Unix()) + MaxBlockTimeDrift + 3600
+	block1 := NewBlock(block0Hash, future, 1, 0, 1, sender, nil)
+	_, err := state.AddBlock(block1)
+	if err == nil {
+		t.Fatal("a block time beyond MaxBlockTimeDrift must be rejected")
+	}
+
+	block1Hash, err := block1.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = state.ValidateHeaders(nil, []BlockHeaderFS{{Key: block1Hash, Value: block1.Header}})
+	if err == nil {
+		t.Fatal("a header time beyond MaxBlockTimeDrift must be rejected")
+	}
+}
+
+func TestState_RebuildMissingBlocksIndex(t *testing.T) {
+	dataDir, _, sender := newTestDataDir(t)
+
+	state := loadTestState(t, dataDir)
+	block0Hash := addTestBlock(t, state, NewBlock(Hash{}, 1, 0, 0, 1, sender, nil))
+	block1Hash := addTestBlock(t, state, NewBlock(block0Hash, 2, 1, 0, 1, sender, nil))
+	state.Close()
+
+	err := os.Remove(getBlocksIndexFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := loadTestState(t, dataDir)
+
+	if loaded.LatestBlockHash() != block1Hash {
+		t.Fatal("blocks missing from the index should be indexed again from block.db")
+	}
+}
+
+func TestState_ValidateHeaders(t *testing.T) {
+	source, _, sender := newTestState(t)
+	block0 := NewBlock(Hash{}, 1, 0, 0, 1, sender, nil)
+	block0Hash := addTestBlock(t, source, block0)
+	block1 := NewBlock(block0Hash, 2, 1, 0, 1, sender, nil)
+	block1Hash := addTestBlock(t, source, block1)
+	block2Hash := addTestBlock(t, source, NewBlock(block1Hash, 3, 2, 0, 1, sender, nil))
+
+	headers, err := source.GetHeadersAfter(Hash{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(headers) != 3 || headers[2].Key != block2Hash {
+		t.Fatal("the whole canonical chain headers should be returned")
+	}
+
+	state, _, _ := newTestState(t)
+
+	err = state.ValidateHeaders(nil, headers[1:])
+	if err == nil {
+		t.Fatal("headers not following a known block should be rejected")
+	}
+
+	forged := append([]BlockHeaderFS{}, headers...)
+	forged[1].Value.Time++
+	err = state.ValidateHeaders(nil, forged)
+	if err == nil {
+		t.Fatal("headers not matching their hash should be rejected")
+	}
+
+	err = state.ValidateHeaders(nil, []BlockHeaderFS{headers[0], headers[2]})
+	if err == nil {
+		t.Fatal("headers skipping a block should be rejected")
+	}
+
+	// The first header was validated earlier, its block isn't added yet
+	err = state.ValidateHeaders(headers[:1], headers[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.HasBlock(block0Hash) {
+		t.Fatal("validating headers should not add blocks")
+	}
+}
+
+func TestState_BlockLocatorFindsForkPoint(t *testing.T) {
+	state, _, sender := newTestState(t)
+
+	// Mined at the expected interval, the difficulty isn't retargeted
+	hashes := []Hash{addTestBlock(t, state, NewBlock(Hash{}, 0, 0, 0, 1, sender, nil))}
+	for number := uint64(1); number < 15; number++ {
+		hashes = append(hashes, addTestBlock(t, state, NewBlock(hashes[number-1], number*DefaultBlockInterval, number, 0, 1, sender, nil)))
+	}
+
+	locator := state.BlockLocator()
+
+	// The 10 latest blocks, then every other block, then the first one
+	expected := []Hash{hashes[14], hashes[13], hashes[12], hashes[11], hashes[10], hashes[9], hashes[8], hashes[7], hashes[6], hashes[5], hashes[3], hashes[0]}
+	if len(locator) != len(expected) {
+		t.Fatalf("locator should list %d hashes, got %d", len(expected), len(locator))
+	}
+
+	for i := range expected {
+		if locator[i] != expected[i] {
+			t.Fatalf("locator hash %d should be '%s'", i, expected[i].Hex())
+		}
+	}
+
+	// A fork of block 4 only knows up to it
+	forkLocator := []Hash{{1}, {2}, hashes[4], hashes[0]}
+	ancestor, err := state.FindCommonAncestor(forkLocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ancestor.Key != hashes[4] || ancestor.Value.Number != 4 {
+		t.Fatal("the fork point should be the latest shared block")
+	}
+
+	_, err = state.FindCommonAncestor([]Hash{{1}, {2}})
+	if err == nil {
+		t.Fatal("a locator of another chain should have no common ancestor")
+	}
+}
+
+const testChainID = "tbb-test"
+
+// newTestState creates a state in a temporary data dir where any block hash is a valid proof-of-work.
+//
+// The returned account owns 1000 TBB in the genesis.
+func newTestState(t *testing.T) (*State, *ecdsa.PrivateKey, common.Address) {
+	dataDir, privKey, account := newTestDataDir(t)
+
+	return loadTestState(t, dataDir), privKey, account
+}
+
+func newTestDataDir(t *testing.T) (string, *ecdsa.PrivateKey, common.Address) {
+	privKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := crypto.PubkeyToAddress(privKey.PublicKey)
+
+	dataDir, err := os.MkdirTemp("", "tbb_state_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { RemoveDir(dataDir) })
+
+	gen := Genesis{
+		Time:      time.Unix(0, 0).UTC(),
+		ChainID:   testChainID,
+		Balances:  map[common.Address]uint{account: 1000},
+		Consensus: ConsensusParams{Difficulty: 1},
+	}
+	genJson, err := json.Marshal(gen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = InitDataDir(dataDir, genJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dataDir, privKey, account
+}
+
+func loadTestState(t *testing.T, dataDir string) *State {
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(state.Close)
+
+	return state
+}
+
+// signTestTx signs the TX for the chain of the test data dirs.
+func signTestTx(t *testing.T, tx Tx, privKey *ecdsa.PrivateKey) SignedTx {
+	tx.ChainID = testChainID
+
+	rawTx, err := tx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txHash := sha256.Sum256(rawTx)
+	sig, err := crypto.Sign(txHash[:], privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSignedTx(tx, sig)
+}
+
+func addTestBlock(t *testing.T, state *State, b Block) Hash {
+	hash, err := state.AddBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func mustHashTx(t *testing.T, tx SignedTx) Hash {
+	txHash, err := tx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return txHash
+}
+
+func TestState_SelectValidTXs(t *testing.T) {
+	state, privKey, sender := newTestState(t)
+	receiver := NewAccount("0x0000000000000000000000000000000000000001")
+
+	tx1 := signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 10, 1, ""), privKey)
+	overspend := signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 5000, 2, ""), privKey)
+	tx3 := signTestTx(t, NewTx(sender, receiver, TxGas, TxGasPriceDefault, 10, 3, ""), privKey)
+
+	valid, invalid := state.SelectValidTXs([]SignedTx{tx1, overspend, tx3})
+
+	if len(valid) != 1 || mustHashTx(t, valid[0]) != mustHashTx(t, tx1) {
+		t.Fatal("only the TX before the invalid one should be selected")
+	}
+
+	if len(invalid) != 1 || invalid[mustHashTx(t, overspend)] == nil {
+		t.Fatal("only the overspending TX should be reported invalid")
+	}
+
+	if state.Balances[sender] != 1000 {
+		t.Fatal("the simulation must not modify the state")
+	}
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const FlatFileStorage = "flatfile"
+const LevelDBStorage = "leveldb"
+
+// Storage persists the blocks of every branch and the data derived from the canonical chain.
+type Storage interface {
+	PutBlock(blockHash Hash, b Block) error
+	GetBlock(blockHash Hash) (Block, error)
+	GetHeader(blockHash Hash) (BlockHeader, error)
+	// BlockHashes returns the hashes of the stored blocks in the order they were put
+	BlockHashes() ([]Hash, error)
+
+	PutTxLocation(txHash Hash, location TxLocation) error
+	DeleteTxLocation(txHash Hash) error
+	GetTxLocation(txHash Hash) (TxLocation, error)
+
+	// PutAccountTx adds the TX to the history of an account, it is either the sender or the receiver
+	PutAccountTx(account common.Address, txHash Hash, location TxLocation) error
+	DeleteAccountTx(account common.Address, txHash Hash, location TxLocation) error
+	// GetAccountTxs returns a page of the account history, the most recent TX first, and the size of the whole history
+	GetAccountTxs(account common.Address, offset int, limit int) ([]Hash, int, error)
+
+	PutSnapshot(snapshot Snapshot) error
+	// GetSnapshot returns an empty snapshot if none was stored yet
+	GetSnapshot() (Snapshot, error)
+
+	Close() error
+}
+
+// TxLocation is the position of a TX in a block of the canonical chain.
+type TxLocation struct {
+	BlockHash   Hash   `json:"block_hash"`
+	BlockNumber uint64 `json:"block_number"`
+	Index       int    `json:"index"`
+}
+
+// before tells if the location comes first in the canonical chain.
+func (l TxLocation) before(other TxLocation) bool {
+	if l.BlockNumber != other.BlockNumber {
+		return l.BlockNumber < other.BlockNumber
+	}
+
+	return l.Index < other.Index
+}
+
+// LocatedTx is a TX of the canonical chain together with its position.
+type LocatedTx struct {
+	Hash     Hash       `json:"hash"`
+	Tx       SignedTx   `json:"tx"`
+	Location TxLocation `json:"location"`
+}
+
+// snapshotVersion changes whenever the data derived from the canonical chain does.
+//
+// Snapshots of another version are ignored, the blocks are replayed to rebuild the TXs indexes.
+const snapshotVersion = 1
+
+// Snapshot is the state of the accounts right after the block BlockHash of the canonical chain.
+type Snapshot struct {
+	Version       int                     `json:"version"`
+	BlockHash     Hash                    `json:"block_hash"`
+	Balances      map[common.Address]uint `json:"balances"`
+	Account2Nonce map[common.Address]uint `json:"account2nonce"`
+}
+
+// InitStorage selects the storage backend of the data dir.
+//
+// The backend of a data dir can't be changed once blocks were stored with another one.
+func InitStorage(dataDir string, backend string) error {
+	if backend != FlatFileStorage && backend != LevelDBStorage {
+		return fmt.Errorf("unknown storage backend '%s'", backend)
+	}
+
+	err := InitDataDirIfNotExists(dataDir, []byte(genesisJson))
+	if err != nil {
+		return err
+	}
+
+	current, err := loadStorageBackend(dataDir)
+	if err != nil {
+		return err
+	}
+
+	if current == backend {
+		return nil
+	}
+
+	if FileExist(getStorageFilePath(dataDir)) || hasFlatFileBlocks(dataDir) {
+		return fmt.Errorf("data dir '%s' already uses the '%s' storage backend", dataDir, current)
+	}
+
+	return os.WriteFile(getStorageFilePath(dataDir), []byte(backend), 0644)
+}
+
+func openStorage(dataDir string) (Storage, error) {
+	backend, err := loadStorageBackend(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	switch backend {
+	case FlatFileStorage:
+		return openFlatFileStorage(dataDir)
+	case LevelDBStorage:
+		return openLevelDBStorage(getLevelDBDirPath(dataDir))
+	}
+
+	return nil, fmt.Errorf("unknown storage backend '%s'", backend)
+}
+
+// loadStorageBackend returns the backend selected for the data dir, data dirs without selection use flat files.
+func loadStorageBackend(dataDir string) (string, error) {
+	if !FileExist(getStorageFilePath(dataDir)) {
+		return FlatFileStorage, nil
+	}
+
+	content, err := os.ReadFile(getStorageFilePath(dataDir))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+func hasFlatFileBlocks(dataDir string) bool {
+	info, err := os.Stat(getBlocksDbFilePath(dataDir))
+
+	return err == nil && info.Size() > 0
+}
@@ -3,24 +3,38 @@ package internal
 import (
 	"crypto/elliptic"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
+// TxGas is the gas every TX consumes, TXs pay it at TxGasPriceDefault TBB per gas at least.
+const TxGas = 21
+const TxGasPriceDefault = 1
+const TxFee = TxGas * TxGasPriceDefault
+
 type Tx struct {
-	From  common.Address `json:"from"`
-	To    common.Address `json:"to"`
-	Value uint           `json:"value"`
-	Nonce uint           `json:"nonce"`
-	Data  string         `json:"data"`
-	Time  uint64         `json:"time"`
+	From     common.Address `json:"from"`
+	To       common.Address `json:"to"`
+	Gas      uint           `json:"gas"`
+	GasPrice uint           `json:"gasPrice"`
+	Value    uint           `json:"value"`
+	Nonce    uint           `json:"nonce"`
+	Data     string         `json:"data"`
+	Time     uint64         `json:"time"`
+	// ChainID of the network the TX is signed for, set when signing, see wallet.SignTx
+	ChainID string `json:"chain_id"`
 }
 
-func NewTx(from, to common.Address, value, nonce uint, data string) Tx {
-	return Tx{from, to, value, nonce, data, uint64(time.Now().Unix())}
+func NewTx(from, to common.Address, gas, gasPrice, value, nonce uint, data string) Tx {
+	return Tx{from, to, gas, gasPrice, value, nonce, data, uint64(time.Now().Unix()), ""}
 }
 
 type SignedTx struct {
@@ -36,6 +50,43 @@ func (t Tx) IsReward() bool {
 	return t.Data == "reward"
 }
 
+// Fee is paid by the sender to the miner of the block including the TX.
+//
+// It's only meaningful for a TX passing ValidateFee, it would overflow otherwise.
+func (t Tx) Fee() uint {
+	return t.Gas * t.GasPrice
+}
+
+// Cost is the amount of TBB the TX takes from the sender balance.
+//
+// It's only meaningful for a TX passing ValidateFee, it would overflow otherwise.
+func (t Tx) Cost() uint {
+	return t.Value + t.Fee()
+}
+
+// ValidateFee checks the gas of the TX, and that its fee and cost don't overflow.
+func (t Tx) ValidateFee() error {
+	if t.Gas != TxGas {
+		return fmt.Errorf("wrong TX. Gas must be '%d', not '%d'", TxGas, t.Gas)
+	}
+
+	if t.GasPrice < TxGasPriceDefault {
+		return fmt.Errorf("wrong TX. Gas price must be at least '%d', not '%d'", TxGasPriceDefault, t.GasPrice)
+	}
+
+	hi, fee := bits.Mul(t.Gas, t.GasPrice)
+	if hi != 0 {
+		return fmt.Errorf("wrong TX. Gas price '%d' overflows the fee", t.GasPrice)
+	}
+
+	_, carry := bits.Add(t.Value, fee, 0)
+	if carry != 0 {
+		return fmt.Errorf("wrong TX. Value '%d' and fee '%d' overflow the cost", t.Value, fee)
+	}
+
+	return nil
+}
+
 func (t Tx) Hash() (Hash, error) {
 	txJson, err := json.Marshal(t)
 	if err != nil {
@@ -75,3 +126,54 @@ func (t SignedTx) IsAuthentic() (bool, error) {
 
 	return recoveredAccount.Hex() == t.From.Hex(), nil
 }
+
+// rlpSignedTx is the compact binary layout of a SignedTx.
+type rlpSignedTx struct {
+	From     common.Address
+	To       common.Address
+	Gas      uint64
+	GasPrice uint64
+	Value    uint64
+	Nonce    uint64
+	Data     string
+	Time     uint64
+	ChainID  string
+	Sig      []byte
+}
+
+// EncodeHex returns the RLP encoding of the signed TX as a 0x prefixed hex string.
+func (t SignedTx) EncodeHex() (string, error) {
+	raw, err := rlp.EncodeToBytes(rlpSignedTx{t.From, t.To, uint64(t.Gas), uint64(t.GasPrice), uint64(t.Value), uint64(t.Nonce), t.Data, t.Time, t.ChainID, t.Sig})
+	if err != nil {
+		return "", err
+	}
+
+	return "0x" + hex.EncodeToString(raw), nil
+}
+
+func DecodeSignedTxHex(rawHex string) (SignedTx, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(rawHex, "0x"))
+	if err != nil {
+		return SignedTx{}, err
+	}
+
+	var decoded rlpSignedTx
+	err = rlp.DecodeBytes(raw, &decoded)
+	if err != nil {
+		return SignedTx{}, err
+	}
+
+	tx := Tx{
+		decoded.From,
+		decoded.To,
+		uint(decoded.Gas),
+		uint(decoded.GasPrice),
+		uint(decoded.Value),
+		uint(decoded.Nonce),
+		decoded.Data,
+		decoded.Time,
+		decoded.ChainID,
+	}
+
+	return NewSignedTx(tx, decoded.Sig), nil
+}
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSignedTx_EncodeHex(t *testing.T) {
+	_, privKey, sender := newTestDataDir(t)
+
+	signedTx := signTestTx(t, NewTx(sender, NewAccount("0x01"), TxGas, TxGasPriceDefault, 10, 1, "reward"), privKey)
+
+	rawTx, err := signedTx.EncodeHex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodedTx, err := DecodeSignedTxHex(rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(signedTx, decodedTx) {
+		t.Fatalf("decoded TX %+v differs from the encoded one %+v", decodedTx, signedTx)
+	}
+
+	ok, err := decodedTx.IsAuthentic()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("decoded TX signature should still be authentic")
+	}
+}
@@ -0,0 +1,162 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// Client calls the /rpc endpoint of a node, it is safe for concurrent use.
+type Client struct {
+	url        string
+	httpClient *http.Client
+	nextID     uint64
+}
+
+// BatchElem is a call sent as part of a batch.
+//
+// Result is filled with the call result, Error is set when the call itself failed.
+type BatchElem struct {
+	Method string
+	Params interface{}
+	Result interface{}
+	Error  error
+}
+
+// NewClient creates a client for the node listening on the given address, e.g. 127.0.0.1:8080.
+func NewClient(tcpAddress string) *Client {
+	return NewClientWithHTTP(fmt.Sprintf("http://%s/rpc", tcpAddress), http.DefaultClient)
+}
+
+func NewClientWithHTTP(url string, httpClient *http.Client) *Client {
+	return &Client{url: url, httpClient: httpClient}
+}
+
+// Call invokes the method and decodes its result into result, unless it is nil.
+//
+// Params is either a slice of positional params or a struct, or map, of named ones.
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	req, err := c.newRequest(method, params)
+	if err != nil {
+		return err
+	}
+
+	var res Response
+	err = c.post(ctx, req, &res)
+	if err != nil {
+		return err
+	}
+
+	return decodeResult(res, result)
+}
+
+// BatchCall sends every call in a single HTTP request.
+//
+// The returned error only reports a failure of the whole batch, each call error is in its element.
+func (c *Client) BatchCall(ctx context.Context, batch []BatchElem) error {
+	reqs := make([]Request, len(batch))
+	byID := make(map[string]int)
+
+	for i, elem := range batch {
+		req, err := c.newRequest(elem.Method, elem.Params)
+		if err != nil {
+			return err
+		}
+
+		reqs[i] = req
+		byID[string(req.ID)] = i
+	}
+
+	var responses []Response
+	err := c.post(ctx, reqs, &responses)
+	if err != nil {
+		return err
+	}
+
+	answered := make(map[int]bool)
+	for _, res := range responses {
+		i, ok := byID[string(res.ID)]
+		if !ok {
+			continue
+		}
+
+		batch[i].Error = decodeResult(res, batch[i].Result)
+		answered[i] = true
+	}
+
+	for i := range batch {
+		if !answered[i] {
+			batch[i].Error = fmt.Errorf("no response to the '%s' call", batch[i].Method)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) newRequest(method string, params interface{}) (Request, error) {
+	req := Request{Version: Version, Method: method}
+	req.ID = json.RawMessage(strconv.FormatUint(atomic.AddUint64(&c.nextID, 1), 10))
+
+	if params != nil {
+		paramsJson, err := json.Marshal(params)
+		if err != nil {
+			return Request{}, err
+		}
+
+		req.Params = paramsJson
+	}
+
+	return req, nil
+}
+
+func (c *Client) post(ctx context.Context, reqBody interface{}, resBody interface{}) error {
+	reqBodyJson, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(reqBodyJson))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpRes, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close()
+
+	resBodyJson, err := io.ReadAll(httpRes.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read response body. %s", err.Error())
+	}
+
+	if httpRes.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status %d", httpRes.StatusCode)
+	}
+
+	err = json.Unmarshal(resBodyJson, resBody)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshal response body. %s", err.Error())
+	}
+
+	return nil
+}
+
+func decodeResult(res Response, result interface{}) error {
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(res.Result, result)
+}
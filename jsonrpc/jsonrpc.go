@@ -0,0 +1,59 @@
+// Package jsonrpc holds the JSON-RPC 2.0 messages exchanged with the node /rpc endpoint and a client for it.
+package jsonrpc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const Version = "2.0"
+
+// Error codes defined by the JSON-RPC 2.0 specification
+const (
+	ParseErrorCode     = -32700
+	InvalidRequestCode = -32600
+	MethodNotFoundCode = -32601
+	InvalidParamsCode  = -32602
+	// ServerErrorCode is returned when the node rejects the call, e.g. an invalid TX
+	ServerErrorCode = -32000
+)
+
+// Request is a call, or a notification when it has no ID.
+//
+// Params are either an array of positional params or an object of named ones.
+type Request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// IsNotification tells if the caller expects no response.
+func (r Request) IsNotification() bool {
+	return r.ID == nil
+}
+
+type Response struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// NewErrorResponse answers the request with the given ID, a null ID when the request couldn't be read.
+func NewErrorResponse(id json.RawMessage, code int, message string) Response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return Response{Version: Version, Error: &Error{code, message}, ID: id}
+}
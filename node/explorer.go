@@ -0,0 +1,133 @@
+package node
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rawdaGastan/learn_block_chain/internal"
+)
+
+// Page sizes of the explorer lists
+const DefaultPageSize = 20
+const MaxPageSize = 100
+
+// blockHandler returns a canonical chain block looked up by 'number', or any known block looked up by 'hash'.
+func blockHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	var b internal.Block
+	var err error
+
+	switch {
+	case r.URL.Query().Has("hash"):
+		blockHash := internal.Hash{}
+		err = blockHash.UnmarshalText([]byte(r.URL.Query().Get("hash")))
+		if err != nil {
+			writeErrRes(w, err)
+			return
+		}
+
+		b, err = node.state.GetBlock(blockHash)
+	case r.URL.Query().Has("number"):
+		var number uint64
+		number, err = strconv.ParseUint(r.URL.Query().Get("number"), 10, 64)
+		if err != nil {
+			writeErrRes(w, err)
+			return
+		}
+
+		b, err = node.state.GetBlockByNumber(number)
+	default:
+		err = fmt.Errorf("either a block 'hash' or 'number' is required")
+	}
+
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	blockHash, err := b.Hash()
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, BlockRes{Hash: blockHash, Block: b})
+}
+
+func latestHeadersHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	count, err := parsePageSize(r, "count")
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, HeadersRes{Headers: node.state.GetLatestHeaders(count)})
+}
+
+func txHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	txHash := internal.Hash{}
+	err := txHash.UnmarshalText([]byte(r.URL.Query().Get("hash")))
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	tx, err := node.state.GetTx(txHash)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, tx)
+}
+
+func accountTxsHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	account := internal.NewAccount(r.URL.Query().Get("account"))
+
+	offset := uint64(0)
+	if r.URL.Query().Has("offset") {
+		var err error
+		offset, err = strconv.ParseUint(r.URL.Query().Get("offset"), 10, 32)
+		if err != nil {
+			writeErrRes(w, err)
+			return
+		}
+	}
+
+	limit, err := parsePageSize(r, "limit")
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	txs, total, err := node.state.GetAccountTxs(account, int(offset), limit)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, AccountTxsRes{Account: account, Total: total, Offset: int(offset), TXs: txs})
+}
+
+// parsePageSize reads the optional size of a list, capped to MaxPageSize.
+func parsePageSize(r *http.Request, name string) (int, error) {
+	return parseLimit(r, name, DefaultPageSize, MaxPageSize)
+}
+
+// parseLimit reads an optional number of items to return, between 1 and max.
+func parseLimit(r *http.Request, name string, defaultLimit int, max int) (int, error) {
+	if !r.URL.Query().Has(name) {
+		return defaultLimit, nil
+	}
+
+	limit, err := strconv.ParseUint(r.URL.Query().Get(name), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	if limit == 0 || limit > uint64(max) {
+		return 0, fmt.Errorf("'%s' must be between 1 and %d", name, max)
+	}
+
+	return int(limit), nil
+}
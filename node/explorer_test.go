@@ -0,0 +1,90 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rawdaGastan/learn_block_chain/internal"
+	"github.com/rawdaGastan/learn_block_chain/wallet"
+)
+
+func TestExplorerHandlers(t *testing.T) {
+	n, privKey, sender := newTestNode(t)
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	signedTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 10, 1, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pendingBlock := NewPendingBlock(n.state.LatestBlockHash(), n.state.NextBlockNumber(), n.state.NextDifficulty(), n.info.Account, []internal.SignedTx{signedTx})
+
+	minedBlock, err := Mine(context.Background(), pendingBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minedBlockHash, err := n.state.AddBlock(minedBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txHash, err := signedTx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockRes := BlockRes{}
+	getExplorerTestRes(t, n, blockHandler, "/block?number=0", &blockRes)
+	if blockRes.Hash != minedBlockHash {
+		t.Fatal("block number 0 should be the mined block")
+	}
+
+	blockRes = BlockRes{}
+	getExplorerTestRes(t, n, blockHandler, "/block?hash="+minedBlockHash.Hex(), &blockRes)
+	if blockRes.Block.Header.Number != 0 {
+		t.Fatal("the mined block should be found by hash")
+	}
+
+	headersRes := HeadersRes{}
+	getExplorerTestRes(t, n, latestHeadersHandler, "/block/latest?count=5", &headersRes)
+	if len(headersRes.Headers) != 1 || headersRes.Headers[0].Key != minedBlockHash {
+		t.Fatal("the mined block should be the only latest header")
+	}
+
+	txRes := internal.LocatedTx{}
+	getExplorerTestRes(t, n, txHandler, "/tx?hash="+txHash.Hex(), &txRes)
+	if txRes.Location.BlockHash != minedBlockHash || txRes.Location.Index != 0 {
+		t.Fatal("the TX should be located in the mined block")
+	}
+
+	accountRes := AccountTxsRes{}
+	getExplorerTestRes(t, n, accountTxsHandler, fmt.Sprintf("/account/txs?account=%s&limit=1", receiver.Hex()), &accountRes)
+	if accountRes.Total != 1 || len(accountRes.TXs) != 1 || accountRes.TXs[0].Hash != txHash {
+		t.Fatal("the TX should be part of the receiver history")
+	}
+
+	res := httptest.NewRecorder()
+	accountTxsHandler(res, httptest.NewRequest(http.MethodGet, "/account/txs?limit=1000", nil), n)
+	if res.Code == http.StatusOK {
+		t.Fatal("page size above the maximum should be rejected")
+	}
+}
+
+func getExplorerTestRes(t *testing.T, n *Node, handler func(http.ResponseWriter, *http.Request, *Node), url string, resBody interface{}) {
+	res := httptest.NewRecorder()
+	handler(res, httptest.NewRequest(http.MethodGet, url, nil), n)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("GET %s failed: %s", url, res.Body.String())
+	}
+
+	err := json.Unmarshal(res.Body.Bytes(), resBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,212 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rawdaGastan/learn_block_chain/internal"
+)
+
+// gossipTXs announces every TX newly added to the pending pool to the known peers.
+//
+// TXs added while an announcement is in flight are batched into the next one.
+func (n *Node) gossipTXs(ctx context.Context) {
+	for {
+		select {
+		case tx := <-n.newPendingTXs:
+			txs := []internal.SignedTx{tx}
+
+		batch:
+			for {
+				select {
+				case tx := <-n.newPendingTXs:
+					txs = append(txs, tx)
+				default:
+					break batch
+				}
+			}
+
+			n.announceTXs(txs)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (n *Node) announceTXs(txs []internal.SignedTx) {
+	hashes := make([]internal.Hash, 0, len(txs))
+	for _, tx := range txs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err)
+			continue
+		}
+
+		hashes = append(hashes, txHash)
+	}
+
+	for _, peer := range n.getKnownPeers() {
+		if n.info.IP == peer.IP && n.info.Port == peer.Port {
+			continue
+		}
+
+		err := peerClient(peer).AnnounceTXs(context.Background(), TxAnnounceReq{From: n.info, Hashes: hashes})
+		if err != nil {
+			fmt.Printf("ERROR: unable to announce TXs to Peer '%s'. %s\n", peer.TcpAddress(), err)
+		}
+	}
+}
+
+// fetchAnnouncedTXs imports the announced TXs that are neither pending nor archived yet.
+func (n *Node) fetchAnnouncedTXs(peer PeerNode, hashes []internal.Hash) {
+	for _, txHash := range hashes {
+		if n.isKnownTX(txHash) {
+			continue
+		}
+
+		tx, err := peerClient(peer).PendingTX(context.Background(), txHash)
+		if err != nil {
+			fmt.Printf("ERROR: unable to fetch TX '%s' from Peer '%s'. %s\n", txHash.Hex(), peer.TcpAddress(), err)
+			continue
+		}
+
+		err = n.AddValidPendingTX(tx, peer)
+		if err != nil {
+			fmt.Printf("ERROR: rejected TX '%s' from Peer '%s'. %s\n", txHash.Hex(), peer.TcpAddress(), err)
+		}
+	}
+}
+
+func (n *Node) isKnownTX(txHash internal.Hash) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.hasTX(txHash)
+}
+
+// hasTX tells if the TX is pending, queued or archived, the caller holds the lock.
+func (n *Node) hasTX(txHash internal.Hash) bool {
+	_, isPending := n.pendingTXs[txHash.Hex()]
+	_, isQueued := n.queuedTXs[txHash.Hex()]
+	_, isArchived := n.archivedTXs[txHash.Hex()]
+
+	return isPending || isQueued || isArchived
+}
+
+// announceBlock pushes a new canonical chain block to the known peers, except the one it came from.
+func (n *Node) announceBlock(b internal.Block, fromPeer PeerNode) {
+	for _, peer := range n.getKnownPeers() {
+		if n.info.IP == peer.IP && n.info.Port == peer.Port {
+			continue
+		}
+
+		if fromPeer.IP == peer.IP && fromPeer.Port == peer.Port {
+			continue
+		}
+
+		err := peerClient(peer).AnnounceBlock(context.Background(), BlockAnnounceReq{From: n.info, Block: b})
+		if err != nil {
+			fmt.Printf("ERROR: unable to announce Block to Peer '%s'. %s\n", peer.TcpAddress(), err)
+		}
+	}
+}
+
+// importBlock validates and adds a block pushed by a peer.
+//
+// The announcing peer is not penalized for an invalid block, anyone can claim to be it.
+// It returns true if the block is new and became the tip of the canonical chain.
+func (n *Node) importBlock(b internal.Block, peer PeerNode) (bool, error) {
+	blockHash, err := b.Hash()
+	if err != nil {
+		return false, err
+	}
+
+	if n.state.HasBlock(blockHash) {
+		return false, nil
+	}
+
+	// Blocks missing in between are left to the periodic sync
+	if !b.Header.Parent.IsEmpty() && !n.state.HasBlock(b.Header.Parent) {
+		return false, fmt.Errorf("parent '%s' of Block '%s' is unknown", b.Header.Parent.Hex(), blockHash.Hex())
+	}
+
+	_, err = n.state.AddBlock(b)
+	if err != nil {
+		return false, fmt.Errorf("invalid Block '%s' announced by Peer '%s'. %s", blockHash.Hex(), peer.TcpAddress(), err)
+	}
+
+	if n.state.LatestBlockHash() != blockHash {
+		return false, nil
+	}
+
+	fmt.Printf("Imported Block '%s' announced by Peer %s\n", blockHash.Hex(), peer.TcpAddress())
+	n.removeMinedPendingTXs(b)
+
+	return true, nil
+}
+
+func blockAnnounceHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req := BlockAnnounceReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	// Only the peers which completed a handshake, in either direction, announce blocks
+	knownPeer, isKnownPeer := node.getKnownPeers()[req.From.TcpAddress()]
+	if !isKnownPeer || knownPeer.Info == nil {
+		writeErrResWithStatus(w, http.StatusForbidden, fmt.Errorf("Peer '%s' didn't complete a handshake", req.From.TcpAddress()))
+		return
+	}
+
+	isNewTip, err := node.importBlock(req.Block, req.From)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if isNewTip {
+		go func() {
+			// Stop mining a block competing with the imported one
+			node.newSyncedBlocks <- req.Block
+		}()
+
+		go node.announceBlock(req.Block, req.From)
+	}
+
+	writeRes(w, BlockAnnounceRes{Success: true})
+}
+
+func txAnnounceHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req := TxAnnounceReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	// Fetch in the background, the announcing peer is not kept waiting
+	go node.fetchAnnouncedTXs(req.From, req.Hashes)
+
+	writeRes(w, TxAnnounceRes{Success: true})
+}
+
+func pendingTXHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	txHash := internal.Hash{}
+	err := txHash.UnmarshalText([]byte(r.URL.Query().Get("hash")))
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	tx, ok := node.getPendingTX(txHash)
+	if !ok {
+		writeErrRes(w, fmt.Errorf("TX '%s' is not pending", txHash.Hex()))
+		return
+	}
+
+	writeRes(w, TxRes{Tx: tx})
+}
@@ -0,0 +1,192 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rawdaGastan/learn_block_chain/internal"
+	"github.com/rawdaGastan/learn_block_chain/wallet"
+)
+
+func TestFetchAnnouncedTXs(t *testing.T) {
+	announcer, privKey, sender := newTestNode(t)
+	n := newTestNodeFor(t, sender)
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pendingTXHandler(w, r, announcer)
+	}))
+	defer server.Close()
+
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, err := net.SplitHostPort(serverUrl.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerPort, err := strconv.ParseUint(port, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := NewPeerNode(host, peerPort, false, true, sender)
+
+	signedTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 10, 1, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txHash, err := signedTx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = announcer.AddPendingTX(signedTx, announcer.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.fetchAnnouncedTXs(peer, []internal.Hash{txHash})
+
+	if _, ok := n.getPendingTX(txHash); !ok {
+		t.Fatal("announced TX should be pending")
+	}
+
+	// Announced again, e.g. by another peer
+	n.fetchAnnouncedTXs(peer, []internal.Hash{txHash})
+
+	if len(n.newPendingTXs) != 1 {
+		t.Fatalf("TX should be gossiped once, got %d times", len(n.newPendingTXs))
+	}
+
+	// Not pending on the announcer
+	unknownTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 10, 2, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknownTxHash, err := unknownTx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.fetchAnnouncedTXs(peer, []internal.Hash{unknownTxHash})
+
+	if len(n.getPendingTXsAsArray()) != 1 {
+		t.Fatal("TX unknown to the announcer should not be pending")
+	}
+}
+
+func TestBlockAnnounceHandler(t *testing.T) {
+	miner, privKey, sender := newTestNode(t)
+	n := newTestNodeFor(t, sender)
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	signedTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 10, 1, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.AddPendingTX(signedTx, n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pendingBlock := NewPendingBlock(
+		miner.state.LatestBlockHash(),
+		miner.state.NextBlockNumber(),
+		miner.state.NextDifficulty(),
+		miner.info.Account,
+		[]internal.SignedTx{signedTx},
+	)
+
+	minedBlock, err := Mine(context.Background(), pendingBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minedBlockHash, err := minedBlock.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tampering with the nonce invalidates the proof of work
+	tamperedBlock := minedBlock
+	tamperedBlock.Header.Nonce++
+
+	res := announceTestBlock(t, n, BlockAnnounceReq{From: miner.info, Block: minedBlock})
+	if res.Code != http.StatusForbidden {
+		t.Fatal("block announced by a peer without a handshake should be refused")
+	}
+
+	minerInfo := miner.peerInfo()
+	minerPeer := miner.info
+	minerPeer.Info = &minerInfo
+	err = n.AddPeer(minerPeer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = announceTestBlock(t, n, BlockAnnounceReq{From: miner.info, Block: tamperedBlock})
+	if res.Code == http.StatusOK {
+		t.Fatal("block with an invalid proof of work should be rejected")
+	}
+
+	if record := n.peerRecords[miner.info.TcpAddress()]; record != nil && record.Score < 0 {
+		t.Fatal("the claimed announcer of an invalid block should not be penalized")
+	}
+
+	res = announceTestBlock(t, n, BlockAnnounceReq{From: miner.info, Block: minedBlock})
+	if res.Code != http.StatusOK {
+		t.Fatalf("mined block should be accepted: %s", res.Body.String())
+	}
+
+	if n.state.LatestBlockHash() != minedBlockHash {
+		t.Fatal("announced block should be the new tip")
+	}
+
+	if len(n.getPendingTXsAsArray()) != 0 {
+		t.Fatal("TX of the announced block should not be pending anymore")
+	}
+
+	select {
+	case <-n.newSyncedBlocks:
+	case <-time.After(time.Second):
+		t.Fatal("announced block should interrupt the mining")
+	}
+
+	// Announced again, e.g. relayed back by another peer
+	res = announceTestBlock(t, n, BlockAnnounceReq{From: miner.info, Block: minedBlock})
+	if res.Code != http.StatusOK {
+		t.Fatalf("known block should be ignored: %s", res.Body.String())
+	}
+
+	select {
+	case <-n.newSyncedBlocks:
+		t.Fatal("known block should not interrupt the mining")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func announceTestBlock(t *testing.T, n *Node, req BlockAnnounceReq) *httptest.ResponseRecorder {
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	blockAnnounceHandler(res, httptest.NewRequest(http.MethodPost, "/node/block", bytes.NewReader(reqJson)), n)
+
+	return res
+}
@@ -0,0 +1,75 @@
+package node
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Versions of the protocol between nodes, peers of a version out of this range are refused
+const ProtocolVersion = 1
+const MinProtocolVersion = 1
+
+// handshakeHandler adds the calling node to the known peers if it is of the same network, and introduces this node back.
+//
+// A node of another network is refused with http.StatusForbidden, the only refusal its peers ban for,
+// the other errors, e.g. full known peers, only delay its next handshake.
+func handshakeHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req := HandshakeReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	err = node.checkPeerInfo(req.Info)
+	if err != nil {
+		fmt.Printf("Refused handshake of Peer '%s'. %s\n", req.Peer.TcpAddress(), err)
+		writeErrResWithStatus(w, http.StatusForbidden, err)
+		return
+	}
+
+	peer := NewPeerNode(req.Peer.IP, req.Peer.Port, false, true, req.Peer.Account)
+	peer.Info = &req.Info
+
+	if knownPeer, ok := node.getKnownPeers()[peer.TcpAddress()]; ok {
+		peer.IsBootstrap = knownPeer.IsBootstrap
+	}
+
+	err = node.AddPeer(peer)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	fmt.Printf("Peer '%s' was added into KnownPeers\n", peer.TcpAddress())
+
+	writeRes(w, HandshakeRes{Peer: node.info, Info: node.peerInfo()})
+}
+
+// peerInfo describes this node to its peers.
+func (n *Node) peerInfo() PeerInfo {
+	return PeerInfo{
+		ProtocolVersion: ProtocolVersion,
+		ChainID:         n.state.ChainID(),
+		GenesisHash:     n.state.GenesisHash(),
+		BestHash:        n.state.LatestBlockHash(),
+		BestNumber:      n.state.LatestBlock().Header.Number,
+	}
+}
+
+// checkPeerInfo tells if a peer speaks the same protocol and is part of the same network.
+func (n *Node) checkPeerInfo(info PeerInfo) error {
+	if info.ProtocolVersion < MinProtocolVersion || info.ProtocolVersion > ProtocolVersion {
+		return fmt.Errorf("protocol version '%d' is not supported, expected between '%d' and '%d'", info.ProtocolVersion, MinProtocolVersion, ProtocolVersion)
+	}
+
+	if info.ChainID != n.state.ChainID() {
+		return fmt.Errorf("chain ID '%s' doesn't match '%s'", info.ChainID, n.state.ChainID())
+	}
+
+	if info.GenesisHash != n.state.GenesisHash() {
+		return fmt.Errorf("genesis '%s' doesn't match '%s'", info.GenesisHash.Hex(), n.state.GenesisHash().Hex())
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJoinKnownPeers_Handshake(t *testing.T) {
+	n, _, account := newTestNode(t)
+	// The test nodes share an address, a node is known to itself
+	n.info.Port = 8086
+	// Same genesis, the same network
+	compatible := newTestNodeFor(t, account)
+	// Another genesis, another network
+	incompatible, _, otherAccount := newTestNode(t)
+
+	compatibleServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshakeHandler(w, r, compatible)
+	}))
+	defer compatibleServer.Close()
+
+	incompatibleServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshakeHandler(w, r, incompatible)
+	}))
+	defer incompatibleServer.Close()
+
+	compatiblePeer := newTestPeer(t, compatibleServer, account)
+	compatiblePeer.Connected = false
+	n.AddPeer(compatiblePeer)
+
+	err := n.joinKnownPeers(context.Background(), compatiblePeer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joined := n.getKnownPeers()[compatiblePeer.TcpAddress()]
+	if !joined.Connected || joined.Info == nil || joined.Info.GenesisHash != n.state.GenesisHash() {
+		t.Fatal("the peer should be connected with its negotiated info")
+	}
+
+	if !compatible.IsKnownPeer(n.info) {
+		t.Fatal("the node should be added to the peer known peers")
+	}
+
+	incompatiblePeer := newTestPeer(t, incompatibleServer, otherAccount)
+	incompatiblePeer.Connected = false
+	n.AddPeer(incompatiblePeer)
+
+	err = n.joinKnownPeers(context.Background(), incompatiblePeer)
+	if err == nil {
+		t.Fatal("a peer of another genesis should refuse the handshake")
+	}
+
+	if n.IsKnownPeer(incompatiblePeer) || incompatible.IsKnownPeer(n.info) {
+		t.Fatal("nodes of different networks should not know each other")
+	}
+}
+
+func TestJoinKnownPeers_FullPeerIsRetried(t *testing.T) {
+	n, _, account := newTestNode(t)
+	// The test nodes share an address, a node is known to itself
+	n.info.Port = 8086
+	full := newTestNodeFor(t, account)
+	full.maxPeers = len(full.getKnownPeers())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshakeHandler(w, r, full)
+	}))
+	defer server.Close()
+
+	peer := newTestPeer(t, server, account)
+	peer.Connected = false
+	n.AddPeer(peer)
+
+	err := n.joinKnownPeers(context.Background(), peer)
+	if err == nil {
+		t.Fatal("a peer with full known peers should refuse the handshake")
+	}
+
+	if !n.IsKnownPeer(peer) || n.isPeerReady(peer) {
+		t.Fatal("a full peer should be kept and retried later")
+	}
+
+	if record := n.peerRecords[peer.TcpAddress()]; !record.BannedUntil.IsZero() || record.Score != 0 {
+		t.Fatal("a full peer should not be penalized")
+	}
+}
@@ -6,53 +6,48 @@ import (
 	"io"
 	"net/http"
 
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/rawdaGastan/learn_block_chain/internal"
+	"github.com/rawdaGastan/learn_block_chain/client"
 )
 
-type TxAddReq struct {
-	From     string `json:"from"`
-	FromPwd  string `json:"from_pwd"`
-	To       string `json:"to"`
-	Gas      uint   `json:"gas"`
-	GasPrice uint   `json:"gasPrice"`
-	Value    uint   `json:"value"`
-	Data     string `json:"data"`
-}
-
-type ErrRes struct {
-	Error string `json:"error"`
-}
-
-type BalancesRes struct {
-	Hash     internal.Hash           `json:"block_hash"`
-	Balances map[common.Address]uint `json:"balances"`
-}
-
-type TxAddRes struct {
-	Success bool `json:"success"`
-}
-
-type StatusRes struct {
-	Hash       internal.Hash       `json:"block_hash"`
-	Number     uint64              `json:"block_number"`
-	KnownPeers map[string]PeerNode `json:"peers_known"`
-	PendingTXs []internal.SignedTx `json:"pending_txs"`
-}
-
-type SyncRes struct {
-	Blocks []internal.Block `json:"blocks"`
-}
+// The requests and responses of the routes are shared with the client package.
+type (
+	TxAddReq         = client.TxAddReq
+	TxSubmitReq      = client.TxSubmitReq
+	ErrRes           = client.ErrRes
+	BalancesRes      = client.BalancesRes
+	TxAddRes         = client.TxAddRes
+	TxSubmitRes      = client.TxSubmitRes
+	NonceRes         = client.NonceRes
+	TxProofRes       = client.TxProofRes
+	BlockRes         = client.BlockRes
+	HeadersRes       = client.HeadersRes
+	AccountTxsRes    = client.AccountTxsRes
+	StatusRes        = client.StatusRes
+	PeersRes         = client.PeersRes
+	AncestorRes      = client.AncestorRes
+	SyncRes          = client.SyncRes
+	TxAnnounceReq    = client.TxAnnounceReq
+	TxAnnounceRes    = client.TxAnnounceRes
+	TxRes            = client.TxRes
+	BlockAnnounceReq = client.BlockAnnounceReq
+	BlockAnnounceRes = client.BlockAnnounceRes
+	AddPeerRes       = client.AddPeerRes
+	PeerInfo         = client.PeerInfo
+	HandshakeReq     = client.HandshakeReq
+	HandshakeRes     = client.HandshakeRes
+	WorkRes          = client.WorkRes
+	WorkSubmitReq    = client.WorkSubmitReq
+	WorkSubmitRes    = client.WorkSubmitRes
+)
 
-type AddPeerRes struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error"`
+func writeErrRes(w http.ResponseWriter, err error) {
+	writeErrResWithStatus(w, http.StatusInternalServerError, err)
 }
 
-func writeErrRes(w http.ResponseWriter, err error) {
-	jsonErrRes, _ := json.Marshal(ErrRes{err.Error()})
+func writeErrResWithStatus(w http.ResponseWriter, status int, err error) {
+	jsonErrRes, _ := json.Marshal(ErrRes{Error: err.Error()})
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusInternalServerError)
+	w.WriteHeader(status)
 	w.Write(jsonErrRes)
 }
 
@@ -82,18 +77,3 @@ func readReq(r *http.Request, reqBody interface{}) error {
 
 	return nil
 }
-
-func readRes(r *http.Response, reqBody interface{}) error {
-	reqBodyJson, err := io.ReadAll(r.Body)
-	if err != nil {
-		return fmt.Errorf("unable to read response body. %s", err.Error())
-	}
-	defer r.Body.Close()
-
-	err = json.Unmarshal(reqBodyJson, reqBody)
-	if err != nil {
-		return fmt.Errorf("unable to unmarshal response body. %s", err.Error())
-	}
-
-	return nil
-}
@@ -0,0 +1,541 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rawdaGastan/learn_block_chain/internal"
+)
+
+// Limits of the TXs pool, pending and queued TXs together
+const MaxPoolTXs = 4096
+const MaxAccountPoolTXs = 64
+
+// How often the changes of the TXs pool are written to disk
+const mempoolFlushInterval = time.Second
+
+// AddPendingTX adds the TX to the pending TXs as is, without validating it.
+func (n *Node) AddPendingTX(tx internal.SignedTx, fromPeer PeerNode) error {
+	txHash, err := tx.Hash()
+	if err != nil {
+		return err
+	}
+
+	txJson, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	isNew := !n.hasTX(txHash)
+	if isNew {
+		fmt.Printf("Added Pending TX %s from Peer %s\n", txJson, fromPeer.TcpAddress())
+		n.pendingTXs[txHash.Hex()] = tx
+		n.markPoolChanged()
+	}
+	n.mu.Unlock()
+
+	if isNew {
+		n.announcePendingTX(tx)
+	}
+
+	return nil
+}
+
+// AddValidPendingTX validates a signed TX and adds it to the pool, already known TXs are ignored.
+//
+// Both happen at once so concurrent TXs of a sender can't be admitted with the same nonce.
+// TXs ahead of the sender next nonce are queued until the TXs before them arrive.
+func (n *Node) AddValidPendingTX(tx internal.SignedTx, fromPeer PeerNode) error {
+	txHash, err := tx.Hash()
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	if n.hasTX(txHash) {
+		n.mu.Unlock()
+		return nil
+	}
+
+	err = n.validatePendingTX(tx)
+	if err == nil {
+		err = n.addToPool(txHash, tx)
+	}
+	if err != nil {
+		n.mu.Unlock()
+		return err
+	}
+
+	fmt.Printf("Added Pending TX %s from Peer %s\n", txHash.Hex(), fromPeer.TcpAddress())
+	n.markPoolChanged()
+	n.mu.Unlock()
+
+	n.announcePendingTX(tx)
+
+	return nil
+}
+
+// announcePendingTX hands the TX to the gossip without waiting for it,
+// TXs it has no room for reach the peers through the sync of their pending TXs.
+//
+// It is called without the lock, the gossip reading the channel takes it too.
+func (n *Node) announcePendingTX(tx internal.SignedTx) {
+	select {
+	case n.newPendingTXs <- tx:
+	default:
+	}
+}
+
+// ValidatePendingTX checks a signed TX can be admitted to the pool.
+//
+// The nonce and balance are checked against the state including the TXs already in the pool from the same sender.
+func (n *Node) ValidatePendingTX(tx internal.SignedTx) error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.validatePendingTX(tx)
+}
+
+func (n *Node) validatePendingTX(tx internal.SignedTx) error {
+	ok, err := tx.IsAuthentic()
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("wrong TX. Sender '%s' is forged", tx.From.String())
+	}
+
+	if tx.ChainID != n.state.ChainID() {
+		return fmt.Errorf("wrong TX. Signed for chain '%s', not '%s'", tx.ChainID, n.state.ChainID())
+	}
+
+	err = tx.ValidateFee()
+	if err != nil {
+		return err
+	}
+
+	expectedNonce := n.nextPendingNonce(tx.From)
+	if tx.Nonce < expectedNonce {
+		return fmt.Errorf("wrong TX. Sender '%s' next nonce must be '%d', not '%d'", tx.From.String(), expectedNonce, tx.Nonce)
+	}
+
+	stateNonce := n.state.GetNextAccountNonce(tx.From)
+	if tx.Nonce >= stateNonce+uint(n.maxAccountPoolTXs) {
+		return fmt.Errorf("wrong TX. Sender '%s' nonce '%d' is too far ahead of its next nonce '%d'", tx.From.String(), tx.Nonce, stateNonce)
+	}
+
+	accountTXs := n.accountPoolTXs(tx.From)
+	if len(accountTXs) >= n.maxAccountPoolTXs {
+		return fmt.Errorf("wrong TX. Sender '%s' already has %d TXs waiting to be mined", tx.From.String(), len(accountTXs))
+	}
+
+	balance := n.state.GetBalance(tx.From)
+
+	pendingCost := tx.Cost()
+	for _, poolTX := range accountTXs {
+		if poolTX.Nonce == tx.Nonce {
+			return fmt.Errorf("wrong TX. Sender '%s' already has a queued TX with nonce '%d'", tx.From.String(), tx.Nonce)
+		}
+
+		// Compared to the balance on the way, so the sum never wraps around
+		if pendingCost > balance || poolTX.Cost() > balance-pendingCost {
+			return fmt.Errorf("wrong TX. Sender '%s' balance is %d TBB. Pending TXs cost more", tx.From.String(), balance)
+		}
+
+		pendingCost += poolTX.Cost()
+	}
+
+	if pendingCost > balance {
+		return fmt.Errorf("wrong TX. Sender '%s' balance is %d TBB. Pending TXs cost is %d TBB", tx.From.String(), balance, pendingCost)
+	}
+
+	return nil
+}
+
+// addToPool inserts a validated TX, making room for it in a full pool when it pays more than the cheapest TX.
+func (n *Node) addToPool(txHash internal.Hash, tx internal.SignedTx) error {
+	if len(n.pendingTXs)+len(n.queuedTXs) >= n.maxPoolTXs {
+		evictedHash, evicted, ok := n.evictionCandidate(tx.From)
+		if !ok {
+			return fmt.Errorf("TXs pool is full")
+		}
+
+		if tx.GasPrice <= evicted.GasPrice {
+			return fmt.Errorf("TXs pool is full. TX gas price must be above %d TBB", evicted.GasPrice)
+		}
+
+		fmt.Printf("Evicting TX %s from the full TXs pool\n", evictedHash)
+
+		delete(n.pendingTXs, evictedHash)
+		delete(n.queuedTXs, evictedHash)
+	}
+
+	if tx.Nonce != n.nextPendingNonce(tx.From) {
+		n.queuedTXs[txHash.Hex()] = tx
+		return nil
+	}
+
+	n.pendingTXs[txHash.Hex()] = tx
+	n.refreshPool()
+
+	return nil
+}
+
+// evictionCandidate returns the TX dropped first from a full pool, the cheapest then oldest one.
+//
+// Only the last TX of each sender can go, earlier ones would leave a nonce gap.
+// The sender of the TX being admitted is skipped for the same reason.
+func (n *Node) evictionCandidate(exceptSender common.Address) (string, internal.SignedTx, bool) {
+	lastTXs := make(map[common.Address]string)
+
+	for _, pool := range []map[string]internal.SignedTx{n.pendingTXs, n.queuedTXs} {
+		for txHash, tx := range pool {
+			last, ok := lastTXs[tx.From]
+			if !ok || tx.Nonce > n.poolTX(last).Nonce {
+				lastTXs[tx.From] = txHash
+			}
+		}
+	}
+
+	candidateHash := ""
+	for sender, txHash := range lastTXs {
+		if sender == exceptSender {
+			continue
+		}
+
+		if candidateHash == "" || isEvictedBefore(n.poolTX(txHash), txHash, n.poolTX(candidateHash), candidateHash) {
+			candidateHash = txHash
+		}
+	}
+
+	if candidateHash == "" {
+		return "", internal.SignedTx{}, false
+	}
+
+	return candidateHash, n.poolTX(candidateHash), true
+}
+
+func isEvictedBefore(tx internal.SignedTx, txHash string, other internal.SignedTx, otherHash string) bool {
+	if tx.GasPrice != other.GasPrice {
+		return tx.GasPrice < other.GasPrice
+	}
+
+	if tx.Time != other.Time {
+		return tx.Time < other.Time
+	}
+
+	return txHash < otherHash
+}
+
+// refreshPool drops the TXs whose nonce got used in the canonical chain
+// and makes pending the queued TXs following the pending ones.
+func (n *Node) refreshPool() {
+	for txHash, tx := range n.pendingTXs {
+		if tx.Nonce < n.state.GetNextAccountNonce(tx.From) {
+			delete(n.pendingTXs, txHash)
+		}
+	}
+
+	queued := make([]string, 0, len(n.queuedTXs))
+	for txHash := range n.queuedTXs {
+		queued = append(queued, txHash)
+	}
+
+	sort.Slice(queued, func(i, j int) bool {
+		return n.queuedTXs[queued[i]].Nonce < n.queuedTXs[queued[j]].Nonce
+	})
+
+	for _, txHash := range queued {
+		tx := n.queuedTXs[txHash]
+
+		switch {
+		case tx.Nonce < n.state.GetNextAccountNonce(tx.From):
+			delete(n.queuedTXs, txHash)
+		case tx.Nonce == n.nextPendingNonce(tx.From):
+			delete(n.queuedTXs, txHash)
+			n.pendingTXs[txHash] = tx
+		}
+	}
+}
+
+// accountPoolTXs returns the pending and queued TXs of the account.
+func (n *Node) accountPoolTXs(account common.Address) []internal.SignedTx {
+	txs := make([]internal.SignedTx, 0)
+
+	for _, pool := range []map[string]internal.SignedTx{n.pendingTXs, n.queuedTXs} {
+		for _, tx := range pool {
+			if tx.From == account {
+				txs = append(txs, tx)
+			}
+		}
+	}
+
+	return txs
+}
+
+func (n *Node) poolTX(txHash string) internal.SignedTx {
+	if tx, ok := n.pendingTXs[txHash]; ok {
+		return tx
+	}
+
+	return n.queuedTXs[txHash]
+}
+
+// GetNextPendingNonce returns the nonce of the next TX of the account, after its pending TXs.
+func (n *Node) GetNextPendingNonce(account common.Address) uint {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.nextPendingNonce(account)
+}
+
+func (n *Node) nextPendingNonce(account common.Address) uint {
+	nonce := n.state.GetNextAccountNonce(account)
+
+	for _, tx := range n.pendingTXs {
+		if tx.From == account && tx.Nonce >= nonce {
+			nonce = tx.Nonce + 1
+		}
+	}
+
+	return nonce
+}
+
+// getPendingTXsAsArray returns the TXs ready to be mined, the queued ones are left out.
+func (n *Node) getPendingTXsAsArray() []internal.SignedTx {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	txs := make([]internal.SignedTx, len(n.pendingTXs))
+
+	i := 0
+	for _, tx := range n.pendingTXs {
+		txs[i] = tx
+		i++
+	}
+
+	return txs
+}
+
+// getPendingTX returns a pending or queued TX.
+func (n *Node) getPendingTX(txHash internal.Hash) (internal.SignedTx, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if tx, ok := n.pendingTXs[txHash.Hex()]; ok {
+		return tx, true
+	}
+
+	tx, ok := n.queuedTXs[txHash.Hex()]
+
+	return tx, ok
+}
+
+func (n *Node) removeMinedPendingTXs(block internal.Block) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.archiveMinedTXs(block)
+}
+
+func (n *Node) archiveMinedTXs(block internal.Block) {
+	if len(block.TXs) > 0 && len(n.pendingTXs) > 0 {
+		fmt.Println("Updating in-memory Pending TXs Pool:")
+	}
+
+	for _, tx := range block.TXs {
+		txHash, _ := tx.Hash()
+		if _, exists := n.pendingTXs[txHash.Hex()]; exists {
+			fmt.Printf("\t-archiving mined TX: %s\n", txHash.Hex())
+
+			n.archivedTXs[txHash.Hex()] = tx
+			delete(n.pendingTXs, txHash.Hex())
+		}
+	}
+
+	if len(block.TXs) > 0 {
+		n.refreshPool()
+		n.markPoolChanged()
+	}
+}
+
+// dropInvalidPendingTXs removes the pending TXs no block can include,
+// the following TXs of their senders are queued again until the nonce gap is filled.
+func (n *Node) dropInvalidPendingTXs(invalidTXs map[internal.Hash]error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for txHash, reason := range invalidTXs {
+		tx, ok := n.pendingTXs[txHash.Hex()]
+		if !ok {
+			continue
+		}
+
+		fmt.Printf("Dropping invalid pending TX %s. %s\n", txHash.Hex(), reason)
+		delete(n.pendingTXs, txHash.Hex())
+
+		for otherHash, other := range n.pendingTXs {
+			if other.From == tx.From && other.Nonce > tx.Nonce {
+				delete(n.pendingTXs, otherHash)
+				n.queuedTXs[otherHash] = other
+			}
+		}
+	}
+
+	n.refreshPool()
+	n.markPoolChanged()
+}
+
+// requeueOrphanedTXs moves the TXs of blocks dropped by a chain reorganization back into the pending pool.
+func (n *Node) requeueOrphanedTXs(orphaned, adopted []internal.Block) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	adoptedTXs := make(map[string]bool)
+	for _, block := range adopted {
+		n.archiveMinedTXs(block)
+
+		for _, tx := range block.TXs {
+			txHash, _ := tx.Hash()
+			adoptedTXs[txHash.Hex()] = true
+		}
+	}
+
+	for _, block := range orphaned {
+		for _, tx := range block.TXs {
+			txHash, _ := tx.Hash()
+			if adoptedTXs[txHash.Hex()] {
+				continue
+			}
+
+			fmt.Printf("\t-re-queuing orphaned TX: %s\n", txHash.Hex())
+
+			delete(n.archivedTXs, txHash.Hex())
+			delete(n.queuedTXs, txHash.Hex())
+			n.pendingTXs[txHash.Hex()] = tx
+		}
+	}
+
+	n.refreshPool()
+	n.markPoolChanged()
+}
+
+// loadPendingTXs restores the TXs pool persisted before the node stopped.
+//
+// Every TX is validated again against the state, the ones mined or invalidated meanwhile are evicted.
+func (n *Node) loadPendingTXs() error {
+	if !internal.FileExist(internal.GetMempoolFilePath(n.dataDir)) {
+		return nil
+	}
+
+	txsJson, err := os.ReadFile(internal.GetMempoolFilePath(n.dataDir))
+	if err != nil {
+		return err
+	}
+
+	var txs []internal.SignedTx
+	err = json.Unmarshal(txsJson, &txs)
+	if err != nil {
+		return fmt.Errorf("unable to read the persisted pending TXs. %s", err.Error())
+	}
+
+	// TXs of a sender are only valid one nonce after the other
+	sort.SliceStable(txs, func(i, j int) bool {
+		return txs[i].Nonce < txs[j].Nonce
+	})
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, tx := range txs {
+		txHash, err := tx.Hash()
+		if err != nil {
+			return err
+		}
+
+		if n.hasTX(txHash) {
+			continue
+		}
+
+		err = n.validatePendingTX(tx)
+		if err == nil {
+			err = n.addToPool(txHash, tx)
+		}
+		if err != nil {
+			fmt.Printf("Evicting persisted pending TX %s. %s\n", txHash.Hex(), err)
+			continue
+		}
+	}
+
+	fmt.Printf("Restored %d pending and %d queued TXs out of %d persisted\n", len(n.pendingTXs), len(n.queuedTXs), len(txs))
+
+	n.markPoolChanged()
+
+	return nil
+}
+
+// markPoolChanged flags the TXs pool to be written to disk by the next flush, the node lock must be held.
+func (n *Node) markPoolChanged() {
+	n.poolChanged = true
+}
+
+// flushPendingTXs periodically writes the TXs pool to disk once it changed, until the context is done.
+func (n *Node) flushPendingTXs(ctx context.Context) {
+	ticker := time.NewTicker(mempoolFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.persistPendingTXs()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// persistPendingTXs replaces the mempool file with the current pending and queued TXs if they changed since the last time.
+//
+// Only the copy of the pool is made under the lock, the handlers and the miner don't wait for the disk.
+func (n *Node) persistPendingTXs() {
+	n.mu.Lock()
+	if !n.poolChanged {
+		n.mu.Unlock()
+		return
+	}
+
+	txs := make([]internal.SignedTx, 0, len(n.pendingTXs)+len(n.queuedTXs))
+	for _, pool := range []map[string]internal.SignedTx{n.pendingTXs, n.queuedTXs} {
+		for _, tx := range pool {
+			txs = append(txs, tx)
+		}
+	}
+	n.poolChanged = false
+	n.mu.Unlock()
+
+	err := writePendingTXs(internal.GetMempoolFilePath(n.dataDir), txs)
+	if err != nil {
+		fmt.Printf("ERROR: unable to persist the pending TXs. %s\n", err)
+
+		// Retried by the next flush
+		n.mu.Lock()
+		n.poolChanged = true
+		n.mu.Unlock()
+	}
+}
+
+func writePendingTXs(path string, txs []internal.SignedTx) error {
+	txsJson, err := json.Marshal(txs)
+	if err != nil {
+		return err
+	}
+
+	return internal.WriteFileAtomic(path, txsJson)
+}
@@ -0,0 +1,168 @@
+package node
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rawdaGastan/learn_block_chain/internal"
+	"github.com/rawdaGastan/learn_block_chain/wallet"
+)
+
+func TestAddValidPendingTX_QueueAndEvict(t *testing.T) {
+	privKeyA, _, accountA, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privKeyB, _, accountB, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := newTestNodeFor(t, accountA, accountB)
+	n.maxPoolTXs = 3
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	signTx := func(privKey *ecdsa.PrivateKey, from common.Address, gasPrice uint, nonce uint) internal.SignedTx {
+		tx, err := wallet.SignTx(internal.NewTx(from, receiver, internal.TxGas, gasPrice, 1, nonce, ""), testChainID, privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tx
+	}
+
+	err = n.AddValidPendingTX(signTx(privKeyA, accountA, 1, 2), n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(n.getPendingTXsAsArray()) != 0 {
+		t.Fatal("TX after a nonce gap should be queued, not pending")
+	}
+
+	err = n.AddValidPendingTX(signTx(privKeyA, accountA, 1, 1), n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(n.getPendingTXsAsArray()) != 2 {
+		t.Fatal("filling the nonce gap should make the queued TX pending")
+	}
+
+	err = n.AddValidPendingTX(signTx(privKeyA, accountA, ^uint(0)/10, 3), n.info)
+	if err == nil {
+		t.Fatal("TX with an overflowing fee should be rejected")
+	}
+
+	err = n.AddValidPendingTX(signTx(privKeyA, accountA, 1, 1+MaxAccountPoolTXs), n.info)
+	if err == nil {
+		t.Fatal("TX too far ahead of the sender nonce should be rejected")
+	}
+
+	cheapTx := signTx(privKeyB, accountB, 1, 1)
+	err = n.AddValidPendingTX(cheapTx, n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.AddValidPendingTX(signTx(privKeyA, accountA, 1, 3), n.info)
+	if err == nil {
+		t.Fatal("full pool should reject a TX not paying more than the cheapest one")
+	}
+
+	err = n.AddValidPendingTX(signTx(privKeyA, accountA, 5, 3), n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cheapTxHash, err := cheapTx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := n.getPendingTX(cheapTxHash); ok || len(n.getPendingTXsAsArray()) != 3 {
+		t.Fatal("the cheapest TX should have been evicted for the better paying one")
+	}
+}
+
+func TestLoadPendingTXs(t *testing.T) {
+	n, privKey, sender := newTestNode(t)
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	var txs []internal.SignedTx
+	for nonce := uint(1); nonce <= 2; nonce++ {
+		tx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 10, nonce, ""), testChainID, privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = n.AddValidPendingTX(tx, n.info)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		txs = append(txs, tx)
+	}
+
+	// Tampered with on disk, its signature doesn't match anymore
+	forgedTx := txs[1]
+	forgedTx.Nonce = 3
+	persisted := append(n.getPendingTXsAsArray(), forgedTx)
+
+	err := writePendingTXs(internal.GetMempoolFilePath(n.dataDir), persisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.state.Close()
+
+	restarted := New(n.dataDir, "127.0.0.1", 8085, sender, PeerNode{})
+	restarted.state, err = internal.NewStateFromDisk(n.dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(restarted.state.Close)
+
+	// The first TX got mined while the node was stopped
+	pendingBlock := NewPendingBlock(internal.Hash{}, 0, restarted.state.NextDifficulty(), sender, txs[:1])
+	minedBlock, err := Mine(context.Background(), pendingBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = restarted.state.AddBlock(minedBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = restarted.loadPendingTXs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.persistPendingTXs()
+
+	pendingTXs := restarted.getPendingTXsAsArray()
+	if len(pendingTXs) != 1 || pendingTXs[0].Nonce != 2 {
+		t.Fatal("only the TX still valid should be restored")
+	}
+
+	txsJson, err := os.ReadFile(internal.GetMempoolFilePath(n.dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rewritten []internal.SignedTx
+	err = json.Unmarshal(txsJson, &rewritten)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rewritten) != 1 {
+		t.Fatal("evicted TXs should be removed from disk")
+	}
+}
@@ -3,76 +3,258 @@ package node
 import (
 	"context"
 	"fmt"
-	"math/rand"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/rawdaGastan/learn_block_chain/internal"
 )
 
+// DefaultMiningWorkers is the number of goroutines searching a block nonce, one per CPU.
+var DefaultMiningWorkers = runtime.NumCPU()
+
+// The hashes are counted, and the cancellation checked, once per batch of nonces
+const miningBatchSize = 1024
+const hashrateReportInterval = 10 * time.Second
+
 type PendingBlock struct {
-	parent internal.Hash
-	number uint64
-	time   uint64
-	miner  common.Address
-	txs    []internal.SignedTx
+	parent     internal.Hash
+	number     uint64
+	time       uint64
+	difficulty uint64
+	miner      common.Address
+	txs        []internal.SignedTx
+}
+
+func NewPendingBlock(parent internal.Hash, number uint64, difficulty uint64, miner common.Address, txs []internal.SignedTx) PendingBlock {
+	return PendingBlock{parent, number, uint64(time.Now().Unix()), difficulty, miner, txs}
 }
 
-func NewPendingBlock(parent internal.Hash, number uint64, miner common.Address, txs []internal.SignedTx) PendingBlock {
-	return PendingBlock{parent, number, uint64(time.Now().Unix()), miner, txs}
+// MiningStats reports the work of a mining run, whether it found a block or not.
+type MiningStats struct {
+	Hashes   uint64
+	Duration time.Duration
+}
+
+// Hashrate is the number of block hashes computed per second.
+func (s MiningStats) Hashrate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+
+	return float64(s.Hashes) / s.Duration.Seconds()
 }
 
+// Mine searches the nonce of the pending block with DefaultMiningWorkers goroutines.
 func Mine(ctx context.Context, pb PendingBlock) (internal.Block, error) {
+	block, _, err := MineWithWorkers(ctx, pb, DefaultMiningWorkers)
+
+	return block, err
+}
+
+// MineWithWorkers splits the nonce space of the pending block between the given number of goroutines.
+//
+// Each worker iterates its share of the nonces in order. Once it's exhausted, the worker moves on
+// to its next extra nonce and rolls the block time, the shares never overlap so no header is hashed twice.
+func MineWithWorkers(ctx context.Context, pb PendingBlock, workers int) (internal.Block, MiningStats, error) {
 	if len(pb.txs) == 0 {
-		return internal.Block{}, fmt.Errorf("mining empty blocks is not allowed")
+		return internal.Block{}, MiningStats{}, fmt.Errorf("mining empty blocks is not allowed")
+	}
+
+	if workers < 1 {
+		workers = 1
 	}
 
 	start := time.Now()
-	attempt := 0
-	var block internal.Block
-	var hash internal.Hash
-	var nonce uint32
+	var hashes uint64
 
-	for !internal.IsBlockHashValid(hash) {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Mining cancelled!")
+	// Only the nonces and the time change between attempts, the Merkle root is computed once
+	block := internal.NewBlock(pb.parent, pb.time, pb.number, 0, pb.difficulty, pb.miner, pb.txs)
 
-			return internal.Block{}, fmt.Errorf("mining cancelled. %s", ctx.Err())
-		default:
-		}
+	workersCtx, stopWorkers := context.WithCancel(ctx)
+	results := make(chan miningResult, workers)
+	var wg sync.WaitGroup
 
-		attempt++
-		nonce = generateNonce()
+	nonceSpace := uint64(math.MaxUint32) + 1
+	share := nonceSpace / uint64(workers)
 
-		if attempt%1000000 == 0 || attempt == 1 {
-			fmt.Printf("Mining %d Pending TXs. Attempt: %d\n", len(pb.txs), attempt)
+	for i := 0; i < workers; i++ {
+		first := uint64(i) * share
+		last := first + share
+		if i == workers-1 {
+			last = nonceSpace
 		}
 
-		block = internal.NewBlock(pb.parent, pb.time, pb.number, nonce, pb.miner, pb.txs)
-		blockHash, err := block.Hash()
-		if err != nil {
-			return internal.Block{}, fmt.Errorf("couldn't mine block. %s", err.Error())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mineNonces(workersCtx, block, first, last, &hashes, results)
+		}()
+	}
+
+	stats := func() MiningStats {
+		return MiningStats{Hashes: atomic.LoadUint64(&hashes), Duration: time.Since(start)}
+	}
+
+	ticker := time.NewTicker(hashrateReportInterval)
+	defer ticker.Stop()
+
+	fmt.Printf("Mining %d Pending TXs with %d workers\n", len(pb.txs), workers)
+
+	var result miningResult
+
+wait:
+	for {
+		select {
+		case result = <-results:
+			break wait
+		case <-ticker.C:
+			current := stats()
+			fmt.Printf("Mining %d Pending TXs. Hashes: %d, Hashrate: %.0f H/s\n", len(pb.txs), current.Hashes, current.Hashrate())
+		case <-ctx.Done():
+			result.err = fmt.Errorf("mining cancelled. %s", ctx.Err())
+			break wait
 		}
+	}
+
+	stopWorkers()
+	wg.Wait()
+
+	if result.err != nil {
+		fmt.Println("Mining cancelled!")
 
-		hash = blockHash
+		return internal.Block{}, stats(), result.err
 	}
 
+	block = result.block
+	hash := result.hash
+	minedStats := stats()
+
 	fmt.Printf("\nMined new Block '%x' using PoW🎉🎉🎉:\n", hash)
 	fmt.Printf("\tHeight: '%v'\n", block.Header.Number)
 	fmt.Printf("\tNonce: '%v'\n", block.Header.Nonce)
+	fmt.Printf("\tExtra nonce: '%v'\n", block.Header.ExtraNonce)
+	fmt.Printf("\tDifficulty: '%v'\n", block.Header.Difficulty)
 	fmt.Printf("\tCreated: '%v'\n", block.Header.Time)
 	fmt.Printf("\tMiner: '%v'\n", block.Header.Miner)
 	fmt.Printf("\tParent: '%v'\n\n", block.Header.Parent.Hex())
 
-	fmt.Printf("\tAttempt: '%v'\n", attempt)
-	fmt.Printf("\tTime: %s\n\n", time.Since(start))
+	fmt.Printf("\tHashes: '%v'\n", minedStats.Hashes)
+	fmt.Printf("\tHashrate: %.0f H/s\n", minedStats.Hashrate())
+	fmt.Printf("\tTime: %s\n\n", minedStats.Duration)
 
-	return block, nil
+	return block, minedStats, nil
 }
 
-func generateNonce() uint32 {
-	rand.Seed(time.Now().UTC().UnixNano())
+// sortTXsByFee orders TXs so the ones paying the highest gas price are mined first.
+//
+// The TXs of a sender keep their nonce order, a TX is only picked once all the previous TXs of its sender are.
+func sortTXsByFee(txs []internal.SignedTx) []internal.SignedTx {
+	bySender := make(map[common.Address][]internal.SignedTx)
+	for _, tx := range txs {
+		bySender[tx.From] = append(bySender[tx.From], tx)
+	}
+
+	for _, senderTXs := range bySender {
+		sort.Slice(senderTXs, func(i, j int) bool {
+			return senderTXs[i].Nonce < senderTXs[j].Nonce
+		})
+	}
+
+	sorted := make([]internal.SignedTx, 0, len(txs))
+	for len(sorted) < len(txs) {
+		var next common.Address
+		found := false
+
+		for sender, senderTXs := range bySender {
+			if len(senderTXs) == 0 {
+				continue
+			}
+
+			if !found || isPaidBefore(senderTXs[0], bySender[next][0]) {
+				next = sender
+				found = true
+			}
+		}
+
+		sorted = append(sorted, bySender[next][0])
+		bySender[next] = bySender[next][1:]
+	}
+
+	return sorted
+}
+
+// isPaidBefore tells if a TX is mined before another, the oldest first on an equal gas price.
+func isPaidBefore(tx, other internal.SignedTx) bool {
+	if tx.GasPrice != other.GasPrice {
+		return tx.GasPrice > other.GasPrice
+	}
+
+	if tx.Time != other.Time {
+		return tx.Time < other.Time
+	}
+
+	return tx.From.Hex() < other.From.Hex()
+}
+
+type miningResult struct {
+	block internal.Block
+	hash  internal.Hash
+	err   error
+}
+
+// mineNonces tries the nonces in [first, last) of the block, then again with the next extra nonce until a valid hash is found.
+//
+// The hashes are added to the shared counter per batch, as the cancellation is checked, to keep the workers from contending on it.
+func mineNonces(ctx context.Context, block internal.Block, first uint64, last uint64, hashes *uint64, results chan<- miningResult) {
+	done := uint64(0)
+	defer func() {
+		atomic.AddUint64(hashes, done)
+	}()
+
+	for extraNonce := uint64(0); ; extraNonce++ {
+		if extraNonce > 0 {
+			block.Header.ExtraNonce = extraNonce
+			block.Header.Time = rollTime(block.Header.Time)
+		}
+
+		for nonce := first; nonce < last; nonce++ {
+			if done == miningBatchSize {
+				atomic.AddUint64(hashes, done)
+				done = 0
+
+				if ctx.Err() != nil {
+					return
+				}
+			}
+
+			block.Header.Nonce = uint32(nonce)
+			done++
+
+			hash, err := block.Hash()
+			if err != nil {
+				results <- miningResult{err: fmt.Errorf("couldn't mine block. %s", err.Error())}
+				return
+			}
+
+			if internal.IsBlockHashValid(hash, block.Header.Difficulty) {
+				results <- miningResult{block: block, hash: hash}
+				return
+			}
+		}
+	}
+}
+
+// rollTime moves the block time forward to now, it never goes back before the time the block was created with.
+func rollTime(blockTime uint64) uint64 {
+	now := uint64(time.Now().Unix())
+	if now > blockTime {
+		return now
+	}
 
-	return rand.Uint32()
+	return blockTime
 }
@@ -15,13 +15,16 @@ import (
 	"github.com/rawdaGastan/learn_block_chain/wallet"
 )
 
+// Low enough for the tests to mine a block in a few seconds
+const testDifficulty uint64 = 1 << 16
+
 func TestValidBlockHash(t *testing.T) {
 	hexHash := "000000fa04f8160395c387277f8b2f14837603383d33809a4db586086168edfa"
 	var hash = internal.Hash{}
 
 	hex.Decode(hash[:], []byte(hexHash))
 
-	isValid := internal.IsBlockHashValid(hash)
+	isValid := internal.IsBlockHashValid(hash, internal.DefaultDifficulty)
 	if !isValid {
 		t.Fatalf("hash '%s' starting with 6 zeroes is suppose to be valid", hexHash)
 	}
@@ -33,7 +36,7 @@ func TestInvalidBlockHash(t *testing.T) {
 
 	hex.Decode(hash[:], []byte(hexHash))
 
-	isValid := internal.IsBlockHashValid(hash)
+	isValid := internal.IsBlockHashValid(hash, internal.DefaultDifficulty)
 	if isValid {
 		t.Fatal("hash is not suppose to be valid")
 	}
@@ -62,7 +65,7 @@ func TestMine(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if !internal.IsBlockHashValid(minedBlockHash) {
+	if !internal.IsBlockHashValid(minedBlockHash, minedBlock.Header.Difficulty) {
 		t.Fatal()
 	}
 
@@ -90,6 +93,75 @@ func TestMineWithTimeout(t *testing.T) {
 	}
 }
 
+func TestMineWithWorkers(t *testing.T) {
+	minerPrivKey, _, miner, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pendingBlock, err := createRandomPendingBlock(minerPrivKey, miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minedBlock, stats, err := MineWithWorkers(context.Background(), pendingBlock, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minedBlockHash, err := minedBlock.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !internal.IsBlockHashValid(minedBlockHash, minedBlock.Header.Difficulty) {
+		t.Fatal("mined block hash should meet its difficulty")
+	}
+
+	if stats.Hashes == 0 || stats.Hashrate() <= 0 {
+		t.Fatal("mining stats should count the computed hashes")
+	}
+}
+
+func TestMineNonces_MovesToNextExtraNonce(t *testing.T) {
+	minerPrivKey, _, miner, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pendingBlock, err := createRandomPendingBlock(minerPrivKey, miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := internal.NewBlock(pendingBlock.parent, pendingBlock.time, pendingBlock.number, 0, pendingBlock.difficulty, pendingBlock.miner, pendingBlock.txs)
+
+	// A share of 3 nonces is exhausted long before a valid hash is found
+	first, last := uint64(5), uint64(8)
+	var hashes uint64
+	results := make(chan miningResult, 1)
+
+	mineNonces(context.Background(), block, first, last, &hashes, results)
+
+	result := <-results
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+
+	header := result.block.Header
+	if uint64(header.Nonce) < first || uint64(header.Nonce) >= last {
+		t.Fatalf("nonce %d is out of the worker share", header.Nonce)
+	}
+
+	if header.ExtraNonce*(last-first)+uint64(header.Nonce)-first+1 != hashes {
+		t.Fatal("the nonces of each extra nonce should be tried once and in order")
+	}
+
+	if header.Time < pendingBlock.time || !internal.IsBlockHashValid(result.hash, header.Difficulty) {
+		t.Fatal("mined block should be valid")
+	}
+}
+
 func generateKey() (*ecdsa.PrivateKey, ecdsa.PublicKey, common.Address, error) {
 	privKey, err := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
 	if err != nil {
@@ -106,8 +178,8 @@ func generateKey() (*ecdsa.PrivateKey, ecdsa.PublicKey, common.Address, error) {
 }
 
 func createRandomPendingBlock(privKey *ecdsa.PrivateKey, acc common.Address) (PendingBlock, error) {
-	tx := internal.NewTx(acc, internal.NewAccount(testKsBabaYagaAccount), 1, 1, "")
-	signedTx, err := wallet.SignTx(tx, privKey)
+	tx := internal.NewTx(acc, internal.NewAccount(testKsBabaYagaAccount), internal.TxGas, internal.TxGasPriceDefault, 1, 1, "")
+	signedTx, err := wallet.SignTx(tx, testChainID, privKey)
 	if err != nil {
 		return PendingBlock{}, err
 	}
@@ -115,7 +187,67 @@ func createRandomPendingBlock(privKey *ecdsa.PrivateKey, acc common.Address) (Pe
 	return NewPendingBlock(
 		internal.Hash{},
 		0,
+		testDifficulty,
 		acc,
 		[]internal.SignedTx{signedTx},
 	), nil
 }
+
+func TestSortTXsByFee(t *testing.T) {
+	alice := internal.NewAccount("0x00000000000000000000000000000000000000aa")
+	bob := internal.NewAccount("0x00000000000000000000000000000000000000bb")
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	aliceTx1 := internal.NewSignedTx(internal.NewTx(alice, receiver, internal.TxGas, 1, 1, 1, ""), nil)
+	aliceTx2 := internal.NewSignedTx(internal.NewTx(alice, receiver, internal.TxGas, 5, 1, 2, ""), nil)
+	bobTx1 := internal.NewSignedTx(internal.NewTx(bob, receiver, internal.TxGas, 3, 1, 1, ""), nil)
+
+	sorted := sortTXsByFee([]internal.SignedTx{aliceTx2, aliceTx1, bobTx1})
+
+	// Alice's best paying TX waits for her previous TX
+	expected := []internal.SignedTx{bobTx1, aliceTx1, aliceTx2}
+	for i, tx := range expected {
+		if sorted[i].From != tx.From || sorted[i].Nonce != tx.Nonce {
+			t.Fatalf("TX %d should be the TX %d of %s, got the TX %d of %s", i, tx.Nonce, tx.From.Hex(), sorted[i].Nonce, sorted[i].From.Hex())
+		}
+	}
+}
+
+func TestMinePendingTXs_DropsInvalidTXs(t *testing.T) {
+	n, privKey, sender := newTestNode(t)
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	validTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 10, 1, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.AddValidPendingTX(validTx, n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Added without validation, spends more than the sender owns
+	overspendTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 5000, 2, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.AddPendingTX(overspendTx, n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.minePendingTXs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(n.state.LatestBlock().TXs) != 1 {
+		t.Fatal("the mined block should only hold the valid TX")
+	}
+
+	if len(n.getPendingTXsAsArray()) != 0 {
+		t.Fatal("the invalid TX should have been dropped from the pool")
+	}
+}
@@ -2,63 +2,100 @@ package node
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/rawdaGastan/learn_block_chain/client"
 	"github.com/rawdaGastan/learn_block_chain/internal"
 )
 
 const miningIntervalSeconds = 10
 const DefaultMiner = "0x0000000000000000000000000000000000000000"
 
-type PeerNode struct {
-	IP          string `json:"ip"`
-	Port        uint64 `json:"port"`
-	IsBootstrap bool   `json:"is_bootstrap"`
-
-	// Whenever my node already established connection, sync with this Peer
-	connected bool
-	Account   common.Address
-}
+type PeerNode = client.PeerNode
 
 type Node struct {
 	dataDir string
 	info    PeerNode
 
 	state           *internal.State
-	knownPeers      map[string]PeerNode
-	pendingTXs      map[string]internal.SignedTx
-	archivedTXs     map[string]internal.SignedTx
 	newSyncedBlocks chan internal.Block
 	newPendingTXs   chan internal.SignedTx
-	isMining        bool
+
+	// mu guards the peers and their reputation, the TXs pools and the mining status,
+	// shared by the HTTP handlers and the sync, mine and gossip goroutines.
+	// pendingTXs can be mined right away, queuedTXs wait for the TXs filling their nonce gap.
+	mu                sync.RWMutex
+	knownPeers        map[string]PeerNode
+	peerRecords       map[string]*peerRecord
+	maxPeers          int
+	pendingTXs        map[string]internal.SignedTx
+	queuedTXs         map[string]internal.SignedTx
+	archivedTXs       map[string]internal.SignedTx
+	maxPoolTXs        int
+	maxAccountPoolTXs int
+	isMining          bool
+	stopCurrentMining context.CancelFunc
+	hashrate          float64
+	// set whenever the TXs pools change, until they're written to disk by persistPendingTXs
+	poolChanged bool
+	// block templates handed out to external miners, oldest first in workIDs
+	works   map[internal.Hash]internal.Block
+	workIDs []internal.Hash
+
+	// zero leaves mining to the external miners, see workHandler
+	miningWorkers int
+
+	// headers validated ahead of their blocks, only used by the sync goroutine, see syncBlocks
+	syncQueue       []internal.BlockHeaderFS
+	syncQueueSize   int
+	syncHeadersPage int
+	syncBlocksBatch int
+	// last block added from the peer chain, the sync resumes after it even while it's on a side branch
+	syncedBlock internal.Hash
 }
 
-func New(dataDir string, ip string, port uint64, acc common.Address, bootstrap PeerNode) *Node {
+// New returns a node knowing its bootstrap peers, the peers found by its previous runs are restored by Run.
+func New(dataDir string, ip string, port uint64, acc common.Address, bootstraps ...PeerNode) *Node {
 	knownPeers := make(map[string]PeerNode)
-	knownPeers[bootstrap.TcpAddress()] = bootstrap
+	for _, bootstrap := range bootstraps {
+		knownPeers[bootstrap.TcpAddress()] = bootstrap
+	}
 
 	return &Node{
-		dataDir:         dataDir,
-		info:            NewPeerNode(ip, port, false, true, acc),
-		knownPeers:      knownPeers,
-		pendingTXs:      make(map[string]internal.SignedTx),
-		archivedTXs:     make(map[string]internal.SignedTx),
-		newSyncedBlocks: make(chan internal.Block),
-		newPendingTXs:   make(chan internal.SignedTx, 10000),
-		isMining:        false,
+		dataDir:           dataDir,
+		info:              NewPeerNode(ip, port, false, true, acc),
+		knownPeers:        knownPeers,
+		peerRecords:       make(map[string]*peerRecord),
+		maxPeers:          MaxPeers,
+		pendingTXs:        make(map[string]internal.SignedTx),
+		queuedTXs:         make(map[string]internal.SignedTx),
+		archivedTXs:       make(map[string]internal.SignedTx),
+		works:             make(map[internal.Hash]internal.Block),
+		maxPoolTXs:        MaxPoolTXs,
+		maxAccountPoolTXs: MaxAccountPoolTXs,
+		newSyncedBlocks:   make(chan internal.Block),
+		newPendingTXs:     make(chan internal.SignedTx, 10000),
+		isMining:          false,
+		miningWorkers:     DefaultMiningWorkers,
+		syncQueueSize:     MaxQueuedHeaders,
+		syncHeadersPage:   MaxSyncHeaders,
+		syncBlocksBatch:   MaxSyncBlocks,
 	}
 }
 
-func (pn PeerNode) TcpAddress() string {
-	return fmt.Sprintf("%s:%d", pn.IP, pn.Port)
+// SetMiningWorkers sets the number of goroutines mining blocks, it must be called before Run.
+//
+// The node doesn't mine with zero workers, blocks are only mined through the external miners work API.
+func (n *Node) SetMiningWorkers(workers int) {
+	n.miningWorkers = workers
 }
 
 func NewPeerNode(ip string, port uint64, isBootstrap bool, connected bool, miner common.Address) PeerNode {
-	return PeerNode{ip, port, isBootstrap, connected, miner}
+	return client.NewPeerNode(ip, port, isBootstrap, connected, miner)
 }
 
 func (n *Node) Run(ctx context.Context) error {
@@ -69,11 +106,28 @@ func (n *Node) Run(ctx context.Context) error {
 		return err
 	}
 	defer state.Close()
+
+	// Goroutines started before Run, e.g. in tests, read the state under the lock
+	n.mu.Lock()
 	n.state = state
+	n.mu.Unlock()
+	n.state.SetReorgHandler(n.requeueOrphanedTXs)
+
+	err = n.loadPendingTXs()
+	if err != nil {
+		return err
+	}
+
+	err = n.loadPeers()
+	if err != nil {
+		return err
+	}
 
 	// Run sync() in a separate thread
 	go n.sync(ctx)
 	go n.mine(ctx)
+	go n.gossipTXs(ctx)
+	go n.flushPendingTXs(ctx)
 
 	http.HandleFunc("/balances/list", func(w http.ResponseWriter, r *http.Request) {
 		listBalancesHandler(w, r, state)
@@ -81,15 +135,69 @@ func (n *Node) Run(ctx context.Context) error {
 	http.HandleFunc("/tx/add", func(w http.ResponseWriter, r *http.Request) {
 		txAddHandler(w, r, n)
 	})
+	http.HandleFunc("/tx/submit", func(w http.ResponseWriter, r *http.Request) {
+		txSubmitHandler(w, r, n)
+	})
+	http.HandleFunc("/tx/proof", func(w http.ResponseWriter, r *http.Request) {
+		txProofHandler(w, r, n)
+	})
+	http.HandleFunc("/tx", func(w http.ResponseWriter, r *http.Request) {
+		txHandler(w, r, n)
+	})
+	http.HandleFunc("/block", func(w http.ResponseWriter, r *http.Request) {
+		blockHandler(w, r, n)
+	})
+	http.HandleFunc("/block/latest", func(w http.ResponseWriter, r *http.Request) {
+		latestHeadersHandler(w, r, n)
+	})
+	http.HandleFunc("/account/txs", func(w http.ResponseWriter, r *http.Request) {
+		accountTxsHandler(w, r, n)
+	})
+	http.HandleFunc("/account/nonce", func(w http.ResponseWriter, r *http.Request) {
+		nonceHandler(w, r, n)
+	})
 	http.HandleFunc("/node/status", func(w http.ResponseWriter, r *http.Request) {
 		statusHandler(w, r, n)
 	})
 	http.HandleFunc("/node/sync", func(w http.ResponseWriter, r *http.Request) {
 		syncHandler(w, r, n)
 	})
+	http.HandleFunc("/node/sync/headers", func(w http.ResponseWriter, r *http.Request) {
+		syncHeadersHandler(w, r, n)
+	})
+	http.HandleFunc("/node/sync/blocks", func(w http.ResponseWriter, r *http.Request) {
+		syncBlocksHandler(w, r, n)
+	})
+	http.HandleFunc("/node/sync/ancestor", func(w http.ResponseWriter, r *http.Request) {
+		syncAncestorHandler(w, r, n)
+	})
+	http.HandleFunc("/node/tx/announce", func(w http.ResponseWriter, r *http.Request) {
+		txAnnounceHandler(w, r, n)
+	})
+	http.HandleFunc("/node/tx", func(w http.ResponseWriter, r *http.Request) {
+		pendingTXHandler(w, r, n)
+	})
+	http.HandleFunc("/node/block", func(w http.ResponseWriter, r *http.Request) {
+		blockAnnounceHandler(w, r, n)
+	})
 	http.HandleFunc("/node/peer", func(w http.ResponseWriter, r *http.Request) {
 		addPeerHandler(w, r, n)
 	})
+	http.HandleFunc("/node/handshake", func(w http.ResponseWriter, r *http.Request) {
+		handshakeHandler(w, r, n)
+	})
+	http.HandleFunc("/node/peers", func(w http.ResponseWriter, r *http.Request) {
+		listPeersHandler(w, r, n)
+	})
+	http.HandleFunc("/mining/work", func(w http.ResponseWriter, r *http.Request) {
+		workHandler(w, r, n)
+	})
+	http.HandleFunc("/mining/submit", func(w http.ResponseWriter, r *http.Request) {
+		submitWorkHandler(w, r, n)
+	})
+	http.HandleFunc("/rpc", func(w http.ResponseWriter, r *http.Request) {
+		rpcHandler(w, r, n)
+	})
 
 	server := &http.Server{Addr: fmt.Sprintf(":%d", n.info.Port)}
 
@@ -99,6 +207,10 @@ func (n *Node) Run(ctx context.Context) error {
 	}()
 
 	err = server.ListenAndServe()
+
+	// The changes of the pool since the last flush
+	n.persistPendingTXs()
+
 	if err != http.ErrServerClosed {
 		return err
 	}
@@ -107,35 +219,31 @@ func (n *Node) Run(ctx context.Context) error {
 }
 
 func (n *Node) mine(ctx context.Context) error {
-	var miningCtx context.Context
-	var stopCurrentMining context.CancelFunc
-
 	ticker := time.NewTicker(time.Second * miningIntervalSeconds)
 
 	for {
 		select {
 		case <-ticker.C:
 			go func() {
-				if len(n.pendingTXs) > 0 && !n.isMining {
-					n.isMining = true
-
-					miningCtx, stopCurrentMining = context.WithCancel(ctx)
-					err := n.minePendingTXs(miningCtx)
-					if err != nil {
-						fmt.Printf("ERROR: %s\n", err)
-					}
+				miningCtx, ok := n.startMining(ctx)
+				if !ok {
+					return
+				}
+				defer n.finishMining()
 
-					n.isMining = false
+				err := n.minePendingTXs(miningCtx)
+				if err != nil {
+					fmt.Printf("ERROR: %s\n", err)
 				}
 			}()
 
 		case block := <-n.newSyncedBlocks:
-			if n.isMining {
+			if n.IsMining() {
 				blockHash, _ := block.Hash()
 				fmt.Printf("\nPeer mined next Block '%s' faster :(\n", blockHash.Hex())
 
 				n.removeMinedPendingTXs(block)
-				stopCurrentMining()
+				n.cancelMining()
 			}
 
 		case <-ctx.Done():
@@ -145,97 +253,163 @@ func (n *Node) mine(ctx context.Context) error {
 	}
 }
 
-func (n *Node) minePendingTXs(ctx context.Context) error {
-	blockToMine := NewPendingBlock(
-		n.state.LatestBlockHash(),
-		n.state.NextBlockNumber(),
-		n.info.Account,
-		n.getPendingTXsAsArray(),
-	)
+// startMining flags the node as mining, unless it already is, has no pending TXs or leaves mining to external miners.
+func (n *Node) startMining(ctx context.Context) (context.Context, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 
-	minedBlock, err := Mine(ctx, blockToMine)
-	if err != nil {
-		return err
+	if len(n.pendingTXs) == 0 || n.isMining || n.miningWorkers == 0 {
+		return nil, false
 	}
 
-	n.removeMinedPendingTXs(minedBlock)
+	miningCtx, stopCurrentMining := context.WithCancel(ctx)
+	n.isMining = true
+	n.stopCurrentMining = stopCurrentMining
 
-	_, err = n.state.AddBlock(minedBlock)
-	if err != nil {
-		return err
+	return miningCtx, true
+}
+
+// cancelMining interrupts the current mining, the node stays flagged as mining until it returns.
+func (n *Node) cancelMining() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopCurrentMining != nil {
+		n.stopCurrentMining()
 	}
+}
 
-	return nil
+func (n *Node) finishMining() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.stopCurrentMining()
+	n.stopCurrentMining = nil
+	n.isMining = false
 }
 
-func (n *Node) AddPendingTX(tx internal.SignedTx, fromPeer PeerNode) error {
-	txHash, err := tx.Hash()
+func (n *Node) IsMining() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.isMining
+}
+
+// minePendingTXs mines a block of the pending TXs valid on top of the canonical chain.
+//
+// The invalid ones are dropped from the pool beforehand, a single one would get the whole block rejected.
+func (n *Node) minePendingTXs(ctx context.Context) error {
+	blockToMine, ok := n.newPendingBlock(n.info.Account)
+	if !ok {
+		return nil
+	}
+
+	minedBlock, stats, err := MineWithWorkers(ctx, blockToMine, n.miningWorkers)
+	n.setHashrate(stats.Hashrate())
 	if err != nil {
 		return err
 	}
 
-	txJson, err := json.Marshal(tx)
+	minedBlockHash, err := n.state.AddBlock(minedBlock)
 	if err != nil {
 		return err
 	}
 
-	_, isAlreadyPending := n.pendingTXs[txHash.Hex()]
-	_, isArchived := n.archivedTXs[txHash.Hex()]
+	// A competing block of the same height may have been accepted first,
+	// the TXs are then kept pending until they land in the canonical chain
+	if n.state.LatestBlockHash() == minedBlockHash {
+		n.removeMinedPendingTXs(minedBlock)
 
-	if !isAlreadyPending && !isArchived {
-		fmt.Printf("Added Pending TX %s from Peer %s\n", txJson, fromPeer.TcpAddress())
-		n.pendingTXs[txHash.Hex()] = tx
-		n.newPendingTXs <- tx
+		go n.announceBlock(minedBlock, n.info)
 	}
 
 	return nil
 }
 
-func (n *Node) getPendingTXsAsArray() []internal.SignedTx {
-	txs := make([]internal.SignedTx, len(n.pendingTXs))
+// newPendingBlock selects the pending TXs valid on top of the canonical chain, for a block paying the given miner.
+//
+// The invalid ones are dropped from the pool, it returns false if no TXs are left to mine.
+func (n *Node) newPendingBlock(miner common.Address) (PendingBlock, bool) {
+	txs, invalidTXs := n.state.SelectValidTXs(sortTXsByFee(n.getPendingTXsAsArray()))
+	if len(invalidTXs) > 0 {
+		n.dropInvalidPendingTXs(invalidTXs)
+	}
 
-	i := 0
-	for _, tx := range n.pendingTXs {
-		txs[i] = tx
-		i++
+	if len(txs) == 0 {
+		return PendingBlock{}, false
 	}
 
-	return txs
+	return NewPendingBlock(
+		n.state.LatestBlockHash(),
+		n.state.NextBlockNumber(),
+		n.state.NextDifficulty(),
+		miner,
+		txs,
+	), true
 }
 
-func (n *Node) removeMinedPendingTXs(block internal.Block) {
-	if len(block.TXs) > 0 && len(n.pendingTXs) > 0 {
-		fmt.Println("Updating in-memory Pending TXs Pool:")
-	}
+// Hashrate is the hashes per second computed while mining the last block, found or not.
+func (n *Node) Hashrate() float64 {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
 
-	for _, tx := range block.TXs {
-		txHash, _ := tx.Hash()
-		if _, exists := n.pendingTXs[txHash.Hex()]; exists {
-			fmt.Printf("\t-archiving mined TX: %s\n", txHash.Hex())
+	return n.hashrate
+}
 
-			n.archivedTXs[txHash.Hex()] = tx
-			delete(n.pendingTXs, txHash.Hex())
-		}
-	}
+func (n *Node) setHashrate(hashrate float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.hashrate = hashrate
 }
 
 func (n *Node) LatestBlockHash() internal.Hash {
 	return n.state.LatestBlockHash()
 }
 
-func (n *Node) AddPeer(peer PeerNode) {
+// AddPeer adds or updates a known peer, a new peer is refused while it's banned or MaxPeers are known.
+func (n *Node) AddPeer(peer PeerNode) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	err := n.canAddPeer(peer)
+	if err != nil {
+		return err
+	}
+
 	n.knownPeers[peer.TcpAddress()] = peer
+
+	return nil
 }
 
 func (n *Node) RemovePeer(peer PeerNode) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
 	delete(n.knownPeers, peer.TcpAddress())
 }
 
+// getKnownPeers returns a copy of the known peers, to iterate over without holding the lock.
+func (n *Node) getKnownPeers() map[string]PeerNode {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	peers := make(map[string]PeerNode, len(n.knownPeers))
+	for address, peer := range n.knownPeers {
+		peers[address] = peer
+	}
+
+	return peers
+}
+
 func (n *Node) IsKnownPeer(peer PeerNode) bool {
 	if peer.IP == n.info.IP && peer.Port == n.info.Port {
 		return true
 	}
 
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
 	_, isKnownPeer := n.knownPeers[peer.TcpAddress()]
 
 	return isKnownPeer
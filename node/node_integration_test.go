@@ -33,6 +33,9 @@ const testKsRawdaFile = "test_rawda--3eb92807f1f91a8d4d85bc908c7f86dcddb1df57"
 const testKsBabaYagaFile = "test_babayaga--6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8"
 const testKsAccountsPwd = "security123"
 
+// testChainID is the chain of the test nodes genesis
+const testChainID = "tbb-test"
+
 func TestNode_Run(t *testing.T) {
 	datadir, err := getTestDataDirPath()
 	if err != nil {
@@ -84,8 +87,8 @@ func TestNode_Mining(t *testing.T) {
 	go func() {
 		time.Sleep(time.Second * miningIntervalSeconds / 3)
 
-		tx := internal.NewTx(rawda, babaYaga, 1, 1, "")
-		signedTx, err := wallet.SignTxWithKeystoreAccount(tx, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
+		tx := internal.NewTx(rawda, babaYaga, internal.TxGas, internal.TxGasPriceDefault, 1, 1, "")
+		signedTx, err := wallet.SignTxWithKeystoreAccount(tx, testChainID, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
 		if err != nil {
 			t.Error(err)
 			return
@@ -99,8 +102,8 @@ func TestNode_Mining(t *testing.T) {
 	go func() {
 		time.Sleep(time.Second*miningIntervalSeconds + 2)
 
-		tx := internal.NewTx(rawda, babaYaga, 2, 2, "")
-		signedTx, err := wallet.SignTxWithKeystoreAccount(tx, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
+		tx := internal.NewTx(rawda, babaYaga, internal.TxGas, internal.TxGasPriceDefault, 2, 2, "")
+		signedTx, err := wallet.SignTxWithKeystoreAccount(tx, testChainID, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
 		if err != nil {
 			t.Error(err)
 			return
@@ -116,7 +119,7 @@ func TestNode_Mining(t *testing.T) {
 		for {
 			select {
 			case <-ticker.C:
-				if n.state.LatestBlock().Header.Number == 1 {
+				if runningState(n).LatestBlock().Header.Number == 1 {
 					closeNode()
 					return
 				}
@@ -151,9 +154,9 @@ func TestNode_ForgedTx(t *testing.T) {
 
 	txValue := uint(5)
 	txNonce := uint(1)
-	tx := internal.NewTx(rawda, babaYaga, txValue, txNonce, "")
+	tx := internal.NewTx(rawda, babaYaga, internal.TxGas, internal.TxGasPriceDefault, txValue, txNonce, "")
 
-	validSignedTx, err := wallet.SignTxWithKeystoreAccount(tx, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
+	validSignedTx, err := wallet.SignTxWithKeystoreAccount(tx, testChainID, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
 	if err != nil {
 		t.Error(err)
 		return
@@ -172,8 +175,8 @@ func TestNode_ForgedTx(t *testing.T) {
 		for {
 			select {
 			case <-ticker.C:
-				if !n.state.LatestBlockHash().IsEmpty() {
-					if wasForgedTxAdded && !n.isMining {
+				if !runningState(n).LatestBlockHash().IsEmpty() {
+					if wasForgedTxAdded && !n.IsMining() {
 						closeNode()
 						return
 					}
@@ -182,7 +185,7 @@ func TestNode_ForgedTx(t *testing.T) {
 						// Attempt to forge the same TX but with modified time
 						// Because the TX.time changed, the TX.signature will be considered forged
 						// internal.NewTx() changes the TX time
-						forgedTx := internal.NewTx(rawda, babaYaga, txValue, txNonce, "")
+						forgedTx := internal.NewTx(rawda, babaYaga, internal.TxGas, internal.TxGasPriceDefault, txValue, txNonce, "")
 						// Use the signature from a valid TX
 						forgedSignedTx := internal.NewSignedTx(forgedTx, validSignedTx.Sig)
 
@@ -202,7 +205,7 @@ func TestNode_ForgedTx(t *testing.T) {
 		t.Fatal("was suppose to mine only one TX. The second TX was forged")
 	}
 
-	if n.state.Balances[babaYaga] != txValue {
+	if n.state.GetBalance(babaYaga) != txValue {
 		t.Fatal("forged tx succeeded")
 	}
 }
@@ -227,9 +230,9 @@ func TestNode_ReplayedTx(t *testing.T) {
 
 	txValue := uint(5)
 	txNonce := uint(1)
-	tx := internal.NewTx(rawda, babaYaga, txValue, txNonce, "")
+	tx := internal.NewTx(rawda, babaYaga, internal.TxGas, internal.TxGasPriceDefault, txValue, txNonce, "")
 
-	signedTx, err := wallet.SignTxWithKeystoreAccount(tx, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
+	signedTx, err := wallet.SignTxWithKeystoreAccount(tx, testChainID, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
 	if err != nil {
 		t.Error(err)
 		return
@@ -244,8 +247,8 @@ func TestNode_ReplayedTx(t *testing.T) {
 		for {
 			select {
 			case <-ticker.C:
-				if !n.state.LatestBlockHash().IsEmpty() {
-					if wasReplayedTxAdded && !n.isMining {
+				if !runningState(n).LatestBlockHash().IsEmpty() {
+					if wasReplayedTxAdded && !n.IsMining() {
 						closeNode()
 						return
 					}
@@ -254,7 +257,9 @@ func TestNode_ReplayedTx(t *testing.T) {
 					// Execute the attack by replaying the TX again!
 					if !wasReplayedTxAdded {
 						// Simulate the TX was submitted to different node
+						n.mu.Lock()
 						n.archivedTXs = make(map[string]internal.SignedTx)
+						n.mu.Unlock()
 						// Execute the attack
 						_ = n.AddPendingTX(signedTx, babaYagaPeerNode)
 						wasReplayedTxAdded = true
@@ -268,12 +273,12 @@ func TestNode_ReplayedTx(t *testing.T) {
 
 	_ = n.Run(ctx)
 
-	if n.state.Balances[babaYaga] == txValue*2 {
+	if n.state.GetBalance(babaYaga) == txValue*2 {
 		t.Errorf("replayed attack was successful :( Damn digital signatures!")
 		return
 	}
 
-	if n.state.Balances[babaYaga] != txValue {
+	if n.state.GetBalance(babaYaga) != txValue {
 		t.Errorf("replayed attack was successful :( Damn digital signatures!")
 		return
 	}
@@ -303,7 +308,7 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 
 	genesisBalances := make(map[common.Address]uint)
 	genesisBalances[rawda] = 1000000
-	genesis := internal.Genesis{Balances: genesisBalances}
+	genesis := internal.Genesis{Time: time.Now().UTC(), ChainID: testChainID, Balances: genesisBalances}
 	genesisJson, err := json.Marshal(genesis)
 	if err != nil {
 		t.Fatal(err)
@@ -332,16 +337,16 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 	// Allow the test to run for 30 mins, in the worst case
 	ctx, closeNode := context.WithTimeout(context.Background(), time.Minute*30)
 
-	tx1 := internal.NewTx(rawda, babaYaga, 1, 1, "")
-	tx2 := internal.NewTx(rawda, babaYaga, 2, 2, "")
+	tx1 := internal.NewTx(rawda, babaYaga, internal.TxGas, internal.TxGasPriceDefault, 1, 1, "")
+	tx2 := internal.NewTx(rawda, babaYaga, internal.TxGas, internal.TxGasPriceDefault, 2, 2, "")
 
-	signedTx1, err := wallet.SignTxWithKeystoreAccount(tx1, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
+	signedTx1, err := wallet.SignTxWithKeystoreAccount(tx1, testChainID, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	signedTx2, err := wallet.SignTxWithKeystoreAccount(tx2, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
+	signedTx2, err := wallet.SignTxWithKeystoreAccount(tx2, testChainID, rawda, testKsAccountsPwd, wallet.GetKeystoreDirPath(dataDir))
 	if err != nil {
 		t.Error(err)
 		return
@@ -355,7 +360,7 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 	// Pre-mine a valid block without running the `n.Run()`
 	// with Rawda as a miner who will receive the block reward,
 	// to simulate the block came on the fly from another peer
-	validPreMinedPb := NewPendingBlock(internal.Hash{}, 0, rawda, []internal.SignedTx{signedTx1})
+	validPreMinedPb := NewPendingBlock(internal.Hash{}, 0, internal.DefaultDifficulty, rawda, []internal.SignedTx{signedTx1})
 	validSyncedBlock, err := Mine(ctx, validPreMinedPb)
 	if err != nil {
 		t.Fatal(err)
@@ -385,11 +390,11 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 	// the synced block
 	go func() {
 		time.Sleep(time.Second * (miningIntervalSeconds + 2))
-		if !n.isMining {
+		if !n.IsMining() {
 			t.Fatal("should be mining")
 		}
 
-		_, err := n.state.AddBlock(validSyncedBlock)
+		_, err := runningState(n).AddBlock(validSyncedBlock)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -397,19 +402,19 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 		n.newSyncedBlocks <- validSyncedBlock
 
 		time.Sleep(time.Second * 2)
-		if n.isMining {
+		if n.IsMining() {
 			t.Fatal("synced block should have canceled mining")
 		}
 
 		// Mined TX1 by Rawda should be removed from the Mempool
-		_, onlyTX2IsPending := n.pendingTXs[tx2Hash.Hex()]
+		_, onlyTX2IsPending := n.getPendingTX(tx2Hash)
 
-		if len(n.pendingTXs) != 1 && !onlyTX2IsPending {
+		if len(n.getPendingTXsAsArray()) != 1 && !onlyTX2IsPending {
 			t.Fatal("synced block should have canceled mining of already mined TX")
 		}
 
 		time.Sleep(time.Second * (miningIntervalSeconds + 2))
-		if !n.isMining {
+		if !n.IsMining() {
 			t.Fatal("should be mining again the 1 TX not included in synced block")
 		}
 	}()
@@ -421,7 +426,7 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 		for {
 			select {
 			case <-ticker.C:
-				if n.state.LatestBlock().Header.Number == 1 {
+				if runningState(n).LatestBlock().Header.Number == 1 {
 					closeNode()
 					return
 				}
@@ -435,20 +440,21 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 		// Take a snapshot of the DB balances
 		// before the mining is finished and the 2 blocks
 		// are created.
-		startingRawdaBalance := n.state.Balances[rawda]
-		startingBabaYagaBalance := n.state.Balances[babaYaga]
+		startingRawdaBalance := runningState(n).GetBalance(rawda)
+		startingBabaYagaBalance := runningState(n).GetBalance(babaYaga)
 
 		// Wait until the 30 mins timeout is reached or
 		// the 2 blocks got already mined and the closeNode() was triggered
 		<-ctx.Done()
 
-		endRawdaBalance := n.state.Balances[rawda]
-		endBabaYagaBalance := n.state.Balances[babaYaga]
+		endRawdaBalance := runningState(n).GetBalance(rawda)
+		endBabaYagaBalance := runningState(n).GetBalance(babaYaga)
 
 		// In TX1 Rawda transferred 1 TBB token to BabaYaga
 		// In TX2 Rawda transferred 2 TBB tokens to BabaYaga
-		expectedEndRawdaBalance := startingRawdaBalance - tx1.Value - tx2.Value + internal.BlockReward
-		expectedEndBabaYagaBalance := startingBabaYagaBalance + tx1.Value + tx2.Value + internal.BlockReward
+		// Each miner earns the fee of the TX in its block
+		expectedEndRawdaBalance := startingRawdaBalance - tx1.Cost() - tx2.Cost() + internal.BlockReward + tx1.Fee()
+		expectedEndBabaYagaBalance := startingBabaYagaBalance + tx1.Value + tx2.Value + internal.BlockReward + tx2.Fee()
 
 		if endRawdaBalance != expectedEndRawdaBalance {
 			t.Fatalf("Rawda expected end balance is %d not %d", expectedEndRawdaBalance, endRawdaBalance)
@@ -470,7 +476,7 @@ func TestNode_MiningStopsOnNewSyncedBlock(t *testing.T) {
 		t.Fatal("was suppose to mine 2 pending TX into 2 valid blocks under 30m")
 	}
 
-	if len(n.pendingTXs) != 0 {
+	if len(n.getPendingTXsAsArray()) != 0 {
 		t.Fatal("no pending TXs should be left to mine")
 	}
 }
@@ -545,7 +551,7 @@ func setupTestNodeDir() (dataDir string, rawda, babaYaga common.Address, err err
 
 	genesisBalances := make(map[common.Address]uint)
 	genesisBalances[rawda] = 1000000
-	genesis := internal.Genesis{Balances: genesisBalances}
+	genesis := internal.Genesis{Time: time.Now().UTC(), ChainID: testChainID, Balances: genesisBalances}
 	genesisJson, err := json.Marshal(genesis)
 	if err != nil {
 		return "", common.Address{}, common.Address{}, err
@@ -563,3 +569,11 @@ func setupTestNodeDir() (dataDir string, rawda, babaYaga common.Address, err err
 
 	return dataDir, rawda, babaYaga, nil
 }
+
+// runningState returns the state loaded by Run, to be read from goroutines started before it.
+func runningState(n *Node) *internal.State {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.state
+}
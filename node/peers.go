@@ -0,0 +1,359 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rawdaGastan/learn_block_chain/client"
+	"github.com/rawdaGastan/learn_block_chain/internal"
+)
+
+// MaxPeers bounds the known peers, the bootstrap peers are always accepted
+const MaxPeers = 32
+
+// Reputation of the peers: every peer starts at 0, a peer reaching BanScore is banned for PeerBanDuration
+const MaxPeerScore = 100
+const BanScore = -100
+const PeerBanDuration = 30 * time.Minute
+
+// Score changes of a peer
+const ScoreGoodResponse = 1
+const ScoreTimeout = -10
+const ScoreMalformedResponse = -20
+const ScoreInvalidBlock = -50
+
+// A peer failing to answer is retried after a delay doubling from MinPeerBackoff to MaxPeerBackoff,
+// it's forgotten after MaxPeerFailures attempts in a row unless it's a bootstrap peer
+const MinPeerBackoff = 10 * time.Second
+const MaxPeerBackoff = 30 * time.Minute
+const MaxPeerFailures = 10
+
+// peerRecord is the reputation and the reconnection state of a peer, kept while it's banned or backed off.
+type peerRecord struct {
+	Score       int       `json:"score"`
+	Failures    int       `json:"failures"`
+	RetryAt     time.Time `json:"retry_at"`
+	BannedUntil time.Time `json:"banned_until"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// misbehaviourError is an error caused by a peer serving invalid data, the peer was already penalized for it.
+type misbehaviourError struct {
+	err error
+}
+
+func (e *misbehaviourError) Error() string {
+	return e.err.Error()
+}
+
+func (e *misbehaviourError) Unwrap() error {
+	return e.err
+}
+
+// isPeerReady tells if the peer can be contacted: neither banned nor waiting for its next reconnection attempt.
+func (n *Node) isPeerReady(peer PeerNode) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	record, ok := n.peerRecords[peer.TcpAddress()]
+	if !ok {
+		return true
+	}
+
+	now := time.Now()
+
+	return !now.Before(record.BannedUntil) && !now.Before(record.RetryAt)
+}
+
+// peerSucceeded rewards a peer for a valid response and resets its reconnection backoff.
+func (n *Node) peerSucceeded(peer PeerNode) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	record := n.peerRecord(peer)
+	record.Failures = 0
+	record.RetryAt = time.Time{}
+	record.LastSeen = time.Now()
+
+	if record.Score < MaxPeerScore {
+		record.Score += ScoreGoodResponse
+	}
+}
+
+// peerFailed penalizes a peer that couldn't be reached or answered with a malformed response, and backs it off.
+//
+// The errors reported by the peer itself and its misbehaviours, penalized where they're detected, are ignored.
+func (n *Node) peerFailed(peer PeerNode, err error) {
+	var apiErr *client.APIError
+	var misbehaviour *misbehaviourError
+	if errors.As(err, &apiErr) || errors.As(err, &misbehaviour) {
+		return
+	}
+
+	score := ScoreTimeout
+	if errors.Is(err, client.ErrMalformedResponse) {
+		score = ScoreMalformedResponse
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.backOffPeer(peer, score)
+}
+
+// peerRefused backs off a peer refusing this node, e.g. because its known peers are full, without lowering its score.
+func (n *Node) peerRefused(peer PeerNode, err error) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.backOffPeer(peer, 0)
+
+	return err
+}
+
+// backOffPeer delays the next attempt to reach the peer and lowers its score by the given amount.
+func (n *Node) backOffPeer(peer PeerNode, score int) {
+	address := peer.TcpAddress()
+	record := n.peerRecord(peer)
+	record.Failures++
+
+	backoff := peerBackoff(record.Failures)
+	record.RetryAt = time.Now().Add(backoff)
+
+	// Handshaken again once reachable
+	if knownPeer, ok := n.knownPeers[address]; ok {
+		knownPeer.Connected = false
+		n.knownPeers[address] = knownPeer
+	}
+
+	if n.lowerScore(peer, score) {
+		return
+	}
+
+	if record.Failures >= MaxPeerFailures && !n.isBootstrap(address) {
+		fmt.Printf("Peer '%s' was removed from KnownPeers after %d failed attempts\n", address, record.Failures)
+
+		delete(n.knownPeers, address)
+		delete(n.peerRecords, address)
+
+		return
+	}
+
+	fmt.Printf("Peer '%s' will be retried in %s\n", address, backoff)
+}
+
+// penalizePeer lowers the score of a peer serving invalid data, the returned error tells the peer was already penalized.
+func (n *Node) penalizePeer(peer PeerNode, score int, err error) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.lowerScore(peer, score)
+
+	return &misbehaviourError{err}
+}
+
+// banPeer bans a peer right away, e.g. a peer of another network.
+func (n *Node) banPeer(peer PeerNode, err error) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.lowerScore(peer, BanScore-n.peerRecord(peer).Score)
+
+	return &misbehaviourError{err}
+}
+
+// lowerScore bans the peer once its score reaches BanScore, it returns true if the peer got banned.
+//
+// The bootstrap peers are never banned, their score doesn't go below BanScore.
+func (n *Node) lowerScore(peer PeerNode, score int) bool {
+	address := peer.TcpAddress()
+	record := n.peerRecord(peer)
+	record.Score += score
+
+	if record.Score > BanScore {
+		return false
+	}
+
+	if n.isBootstrap(address) {
+		record.Score = BanScore
+
+		return false
+	}
+
+	fmt.Printf("Peer '%s' was banned until %s\n", address, time.Now().Add(PeerBanDuration).Format(time.RFC3339))
+
+	record.Score = 0
+	record.BannedUntil = time.Now().Add(PeerBanDuration)
+	delete(n.knownPeers, address)
+
+	return true
+}
+
+// peerBackoff is the delay before the next attempt to reach a peer, after the given number of failed attempts.
+func peerBackoff(failures int) time.Duration {
+	backoff := MinPeerBackoff
+	for i := 1; i < failures && backoff < MaxPeerBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > MaxPeerBackoff {
+		return MaxPeerBackoff
+	}
+
+	return backoff
+}
+
+func (n *Node) peerRecord(peer PeerNode) *peerRecord {
+	record, ok := n.peerRecords[peer.TcpAddress()]
+	if !ok {
+		record = &peerRecord{}
+		n.peerRecords[peer.TcpAddress()] = record
+	}
+
+	return record
+}
+
+func (n *Node) isBootstrap(address string) bool {
+	knownPeer, ok := n.knownPeers[address]
+
+	return ok && knownPeer.IsBootstrap
+}
+
+// canAddPeer tells if a new peer can be known: it isn't banned and the known peers are not full.
+func (n *Node) canAddPeer(peer PeerNode) error {
+	address := peer.TcpAddress()
+	if _, ok := n.knownPeers[address]; ok {
+		return nil
+	}
+
+	if record, ok := n.peerRecords[address]; ok && time.Now().Before(record.BannedUntil) {
+		return fmt.Errorf("Peer '%s' is banned until %s", address, record.BannedUntil.Format(time.RFC3339))
+	}
+
+	if !peer.IsBootstrap && len(n.knownPeers) >= n.maxPeers {
+		return fmt.Errorf("unable to add Peer '%s', %d peers are known already", address, n.maxPeers)
+	}
+
+	return nil
+}
+
+// addressBook is the known peers and the banned ones, as persisted in the data dir.
+type addressBook struct {
+	Peers []addressBookPeer `json:"peers"`
+	// banned peers are not known anymore, only their record is kept until the ban ends
+	Banned map[string]peerRecord `json:"banned"`
+}
+
+// addressBookPeer is a known peer and its reputation.
+type addressBookPeer struct {
+	Peer   PeerNode   `json:"peer"`
+	Record peerRecord `json:"record"`
+}
+
+// loadPeers adds the peers persisted by the previous run to the known peers, so the node can rejoin the network
+// even if its bootstrap peers are down, and restores the bans still running.
+//
+// The restored peers are handshaken again before syncing. The configured bootstrap peers are kept as is,
+// only their reputation is restored.
+func (n *Node) loadPeers() error {
+	if !internal.FileExist(internal.GetPeersFilePath(n.dataDir)) {
+		return nil
+	}
+
+	bookJson, err := os.ReadFile(internal.GetPeersFilePath(n.dataDir))
+	if err != nil {
+		return err
+	}
+
+	var book addressBook
+	err = json.Unmarshal(bookJson, &book)
+	if err != nil {
+		return fmt.Errorf("unable to read the persisted peers. %s", err.Error())
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	for address, persisted := range book.Banned {
+		if now.Before(persisted.BannedUntil) && !n.isBootstrap(address) {
+			record := persisted
+			n.peerRecords[address] = &record
+		}
+	}
+
+	restored := 0
+	for _, persisted := range book.Peers {
+		if persisted.Peer.IP == n.info.IP && persisted.Peer.Port == n.info.Port {
+			continue
+		}
+
+		address := persisted.Peer.TcpAddress()
+		record := persisted.Record
+
+		if n.isBootstrap(address) {
+			n.peerRecords[address] = &record
+			restored++
+
+			continue
+		}
+
+		peer := NewPeerNode(persisted.Peer.IP, persisted.Peer.Port, false, false, persisted.Peer.Account)
+		if n.canAddPeer(peer) != nil {
+			continue
+		}
+
+		n.peerRecords[address] = &record
+		n.knownPeers[address] = peer
+		restored++
+	}
+
+	fmt.Printf("Restored %d known peers out of %d persisted, %d banned\n", restored, len(book.Peers), len(book.Banned))
+
+	return nil
+}
+
+// persistPeers replaces the address book with the known peers, their last time seen and their score,
+// and the peers still banned.
+func (n *Node) persistPeers() {
+	book := addressBook{Banned: make(map[string]peerRecord)}
+
+	n.mu.RLock()
+	for address, peer := range n.knownPeers {
+		if peer.IP == n.info.IP && peer.Port == n.info.Port {
+			continue
+		}
+
+		persisted := addressBookPeer{Peer: peer}
+		if record, ok := n.peerRecords[address]; ok {
+			persisted.Record = *record
+		}
+
+		book.Peers = append(book.Peers, persisted)
+	}
+
+	now := time.Now()
+	for address, record := range n.peerRecords {
+		if _, ok := n.knownPeers[address]; !ok && now.Before(record.BannedUntil) {
+			book.Banned[address] = *record
+		}
+	}
+	n.mu.RUnlock()
+
+	sort.Slice(book.Peers, func(i, j int) bool {
+		return book.Peers[i].Peer.TcpAddress() < book.Peers[j].Peer.TcpAddress()
+	})
+
+	bookJson, err := json.Marshal(book)
+	if err == nil {
+		err = internal.WriteFileAtomic(internal.GetPeersFilePath(n.dataDir), bookJson)
+	}
+
+	if err != nil {
+		fmt.Printf("ERROR: unable to persist the known peers. %s\n", err)
+	}
+}
@@ -0,0 +1,127 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rawdaGastan/learn_block_chain/client"
+)
+
+func TestPeerFailed_BacksOffAndForgets(t *testing.T) {
+	n, _, account := newTestNode(t)
+
+	peer := NewPeerNode("127.0.0.1", 9001, false, true, account)
+	n.AddPeer(peer)
+
+	n.peerFailed(peer, errors.New("connection refused"))
+
+	if n.isPeerReady(peer) || !n.IsKnownPeer(peer) {
+		t.Fatal("an unreachable peer should be kept and backed off")
+	}
+
+	if peerBackoff(2) != 2*MinPeerBackoff || peerBackoff(100) != MaxPeerBackoff {
+		t.Fatal("the backoff should double up to MaxPeerBackoff")
+	}
+
+	for i := 1; i < MaxPeerFailures; i++ {
+		n.peerFailed(peer, errors.New("connection refused"))
+	}
+
+	if n.IsKnownPeer(peer) {
+		t.Fatal("a peer failing MaxPeerFailures times should be forgotten")
+	}
+}
+
+func TestPenalizePeer_BansPeer(t *testing.T) {
+	n, _, account := newTestNode(t)
+
+	peer := NewPeerNode("127.0.0.1", 9001, false, true, account)
+	bootstrap := NewPeerNode("127.0.0.1", 9002, true, true, account)
+	n.AddPeer(peer)
+	n.AddPeer(bootstrap)
+
+	for i := 0; i*-ScoreInvalidBlock < -BanScore; i++ {
+		n.penalizePeer(peer, ScoreInvalidBlock, errors.New("invalid block"))
+		n.penalizePeer(bootstrap, ScoreInvalidBlock, errors.New("invalid block"))
+	}
+
+	if n.IsKnownPeer(peer) || n.isPeerReady(peer) {
+		t.Fatal("a peer reaching BanScore should be banned")
+	}
+
+	if n.AddPeer(peer) == nil {
+		t.Fatal("a banned peer should not be added back")
+	}
+
+	n.peerFailed(bootstrap, fmt.Errorf("%w. truncated body", client.ErrMalformedResponse))
+
+	if !n.IsKnownPeer(bootstrap) {
+		t.Fatal("a bootstrap peer should never be banned")
+	}
+}
+
+func TestAddPeer_MaxPeers(t *testing.T) {
+	n, _, account := newTestNode(t)
+	n.maxPeers = len(n.getKnownPeers()) + 1
+
+	err := n.AddPeer(NewPeerNode("127.0.0.1", 9001, false, true, account))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.AddPeer(NewPeerNode("127.0.0.1", 9002, false, true, account)) == nil {
+		t.Fatal("no peer should be added once MaxPeers are known")
+	}
+
+	err = n.AddPeer(NewPeerNode("127.0.0.1", 9003, true, true, account))
+	if err != nil {
+		t.Fatal("a bootstrap peer should be added whatever the known peers", err)
+	}
+}
+
+func TestLoadPeers_RestoresAddressBook(t *testing.T) {
+	n, _, account := newTestNode(t)
+
+	peer := NewPeerNode("127.0.0.1", 9001, false, true, account)
+	n.AddPeer(peer)
+	n.peerSucceeded(peer)
+
+	bootstrap := NewPeerNode("127.0.0.1", 9002, true, true, account)
+	n.AddPeer(bootstrap)
+	n.peerSucceeded(bootstrap)
+
+	banned := NewPeerNode("127.0.0.1", 9003, false, true, account)
+	n.AddPeer(banned)
+	n.banPeer(banned, errors.New("another network"))
+
+	n.persistPeers()
+
+	// Restarted while its bootstrap peer is down, the bootstrap account is only learnt in the handshake
+	configured := NewPeerNode("127.0.0.1", 9002, true, false, common.Address{})
+	restarted := New(n.dataDir, "127.0.0.1", 8085, account, configured)
+
+	err := restarted.loadPeers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, ok := restarted.getKnownPeers()[peer.TcpAddress()]
+	if !ok || restored.Connected || restored.Account != account {
+		t.Fatal("the persisted peer should be known again and handshaken before syncing")
+	}
+
+	record := restarted.peerRecords[peer.TcpAddress()]
+	if record == nil || record.Score != ScoreGoodResponse || record.LastSeen.IsZero() {
+		t.Fatal("the score and the last time seen of the peer should be restored")
+	}
+
+	if restarted.getKnownPeers()[configured.TcpAddress()] != configured || restarted.peerRecords[configured.TcpAddress()].Score != ScoreGoodResponse {
+		t.Fatal("the configured bootstrap peer should be kept with its persisted reputation")
+	}
+
+	if restarted.AddPeer(banned) == nil {
+		t.Fatal("the bans should survive a restart")
+	}
+}
@@ -11,7 +11,9 @@ import (
 )
 
 func listBalancesHandler(w http.ResponseWriter, r *http.Request, state *internal.State) {
-	writeRes(w, BalancesRes{state.LatestBlockHash(), state.Balances})
+	blockHash, balances := state.GetBalances()
+
+	writeRes(w, BalancesRes{Hash: blockHash, Balances: balances})
 }
 
 func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
@@ -34,16 +36,26 @@ func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		return
 	}
 
-	nonce := node.state.GetNextAccountNonce(from)
-	tx := internal.NewTx(from, internal.NewAccount(req.To), req.Value, nonce, req.Data)
+	gas := req.Gas
+	if gas == 0 {
+		gas = internal.TxGas
+	}
+
+	gasPrice := req.GasPrice
+	if gasPrice == 0 {
+		gasPrice = internal.TxGasPriceDefault
+	}
+
+	nonce := node.GetNextPendingNonce(from)
+	tx := internal.NewTx(from, internal.NewAccount(req.To), gas, gasPrice, req.Value, nonce, req.Data)
 
-	signedTx, err := wallet.SignTxWithKeystoreAccount(tx, from, req.FromPwd, wallet.GetKeystoreDirPath(node.dataDir))
+	signedTx, err := wallet.SignTxWithKeystoreAccount(tx, node.state.ChainID(), from, req.FromPwd, wallet.GetKeystoreDirPath(node.dataDir))
 	if err != nil {
 		writeErrRes(w, err)
 		return
 	}
 
-	err = node.AddPendingTX(signedTx, node.info)
+	err = node.AddValidPendingTX(signedTx, node.info)
 	if err != nil {
 		writeErrRes(w, err)
 		return
@@ -52,17 +64,86 @@ func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	writeRes(w, TxAddRes{Success: true})
 }
 
+func txSubmitHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req := TxSubmitReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	var signedTx internal.SignedTx
+
+	switch {
+	case req.Raw != "":
+		signedTx, err = internal.DecodeSignedTxHex(req.Raw)
+		if err != nil {
+			writeErrRes(w, fmt.Errorf("unable to decode raw TX. %s", err.Error()))
+			return
+		}
+	case req.Tx != nil:
+		signedTx = *req.Tx
+	default:
+		writeErrRes(w, fmt.Errorf("either a signed 'tx' or its 'raw' hex encoding is required"))
+		return
+	}
+
+	txHash, err := signedTx.Hash()
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	err = node.AddValidPendingTX(signedTx, node.info)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, TxSubmitRes{Success: true, Hash: txHash})
+}
+
+func nonceHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	account := internal.NewAccount(r.URL.Query().Get("account"))
+
+	writeRes(w, NonceRes{Account: account, Nonce: node.GetNextPendingNonce(account), ChainID: node.state.ChainID()})
+}
+
+func txProofHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	txHash := internal.Hash{}
+	err := txHash.UnmarshalText([]byte(r.URL.Query().Get("hash")))
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	blockHash, header, proof, err := node.state.GetTxProof(txHash)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, TxProofRes{TxHash: txHash, BlockHash: blockHash, Header: header, Proof: proof})
+}
+
 func statusHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	res := StatusRes{
-		Hash:       node.state.LatestBlockHash(),
-		Number:     node.state.LatestBlock().Header.Number,
-		KnownPeers: node.knownPeers,
-		PendingTXs: node.getPendingTXsAsArray(),
+		Hash:            node.state.LatestBlockHash(),
+		Number:          node.state.LatestBlock().Header.Number,
+		TotalDifficulty: node.state.TotalDifficulty(),
+		KnownPeers:      node.getKnownPeers(),
+		PendingTXs:      node.getPendingTXsAsArray(),
+		Hashrate:        uint64(node.Hashrate()),
 	}
 
 	writeRes(w, res)
 }
 
+func listPeersHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	writeRes(w, PeersRes{KnownPeers: node.getKnownPeers()})
+}
+
+// syncHandler returns a page of the canonical chain blocks following 'fromBlock', at most MaxSyncBlocks.
 func syncHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	// What's your latest block?
 	// I will check my state, if I have newer blocks
@@ -73,8 +154,65 @@ func syncHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		writeErrRes(w, err)
 		return
 	}
+
+	limit, err := parseLimit(r, "limit", MaxSyncBlocks, MaxSyncBlocks)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
 	// Read newer blocks from the DB
-	blocks, err := internal.GetBlocksAfter(hash, node.dataDir)
+	blocks, err := node.state.GetBlocksAfter(hash, limit)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, SyncRes{Blocks: blocks})
+}
+
+// syncHeadersHandler returns a page of the canonical chain headers following 'fromBlock', at most MaxSyncHeaders.
+func syncHeadersHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	hash := internal.Hash{}
+	err := hash.UnmarshalText([]byte(r.URL.Query().Get("fromBlock")))
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	limit, err := parseLimit(r, "limit", MaxSyncHeaders, MaxSyncHeaders)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	headers, err := node.state.GetHeadersAfter(hash, limit)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, HeadersRes{Headers: headers})
+}
+
+// syncBlocksHandler returns the blocks of the given 'hash' values, at most MaxSyncBlocks.
+func syncBlocksHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	rawHashes := r.URL.Query()["hash"]
+	if len(rawHashes) == 0 || len(rawHashes) > MaxSyncBlocks {
+		writeErrRes(w, fmt.Errorf("between 1 and %d block hashes are required", MaxSyncBlocks))
+		return
+	}
+
+	hashes := make([]internal.Hash, len(rawHashes))
+	for i, rawHash := range rawHashes {
+		err := hashes[i].UnmarshalText([]byte(rawHash))
+		if err != nil {
+			writeErrRes(w, err)
+			return
+		}
+	}
+
+	blocks, err := node.state.GetBlocks(hashes)
 	if err != nil {
 		writeErrRes(w, err)
 		return
@@ -83,21 +221,52 @@ func syncHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	writeRes(w, SyncRes{Blocks: blocks})
 }
 
+// syncAncestorHandler returns the first block of the 'locator' hashes found in the canonical chain.
+func syncAncestorHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	rawLocator := r.URL.Query()["locator"]
+	if len(rawLocator) == 0 || len(rawLocator) > MaxLocatorHashes {
+		writeErrRes(w, fmt.Errorf("between 1 and %d locator hashes are required", MaxLocatorHashes))
+		return
+	}
+
+	locator := make([]internal.Hash, len(rawLocator))
+	for i, rawHash := range rawLocator {
+		err := locator[i].UnmarshalText([]byte(rawHash))
+		if err != nil {
+			writeErrRes(w, err)
+			return
+		}
+	}
+
+	ancestor, err := node.state.FindCommonAncestor(locator)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, AncestorRes{Hash: ancestor.Key, Number: ancestor.Value.Number})
+}
+
 func addPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	peerIP := r.URL.Query().Get("ip")
 	peerPortRaw := r.URL.Query().Get("port")
 	minerRaw := r.URL.Query().Get("miner")
 	peerPort, err := strconv.ParseUint(peerPortRaw, 10, 32)
 	if err != nil {
-		writeRes(w, AddPeerRes{false, err.Error()})
+		writeRes(w, AddPeerRes{Success: false, Error: err.Error()})
 		return
 	}
 
-	peer := NewPeerNode(peerIP, peerPort, false, true, internal.NewAccount(minerRaw))
+	// Not connected until the sync handshakes with it, a peer of another network is dropped then
+	peer := NewPeerNode(peerIP, peerPort, false, false, internal.NewAccount(minerRaw))
 
-	node.AddPeer(peer)
+	err = node.AddPeer(peer)
+	if err != nil {
+		writeRes(w, AddPeerRes{Success: false, Error: err.Error()})
+		return
+	}
 
 	fmt.Printf("Peer '%s' was added into KnownPeers\n", peer.TcpAddress())
 
-	writeRes(w, AddPeerRes{true, ""})
+	writeRes(w, AddPeerRes{Success: true})
 }
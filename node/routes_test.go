@@ -0,0 +1,194 @@
+package node
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rawdaGastan/learn_block_chain/internal"
+	"github.com/rawdaGastan/learn_block_chain/wallet"
+)
+
+func TestTxSubmitHandler(t *testing.T) {
+	n, privKey, sender := newTestNode(t)
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	signedTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 10, 1, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawTx, err := signedTx.EncodeHex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := submitTestTx(t, n, TxSubmitReq{Raw: rawTx})
+	if res.Code != http.StatusOK {
+		t.Fatalf("valid raw TX should be accepted: %s", res.Body.String())
+	}
+
+	if len(n.getPendingTXsAsArray()) != 1 {
+		t.Fatal("accepted TX should be pending")
+	}
+
+	// Same nonce as the already pending TX
+	replayedTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 20, 1, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = submitTestTx(t, n, TxSubmitReq{Tx: &replayedTx})
+	if res.Code == http.StatusOK {
+		t.Fatal("TX reusing a pending nonce should be rejected")
+	}
+
+	// Together with the pending TX it exceeds the sender balance
+	overspendTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 995, 2, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = submitTestTx(t, n, TxSubmitReq{Tx: &overspendTx})
+	if res.Code == http.StatusOK {
+		t.Fatal("TX exceeding the sender balance should be rejected")
+	}
+
+	// Not paying the TX gas
+	noFeeTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, 0, 1, 2, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = submitTestTx(t, n, TxSubmitReq{Tx: &noFeeTx})
+	if res.Code == http.StatusOK {
+		t.Fatal("TX without fee should be rejected")
+	}
+
+	forgedTx := internal.NewSignedTx(internal.NewTx(receiver, sender, internal.TxGas, internal.TxGasPriceDefault, 1, 1, ""), signedTx.Sig)
+
+	res = submitTestTx(t, n, TxSubmitReq{Tx: &forgedTx})
+	if res.Code == http.StatusOK {
+		t.Fatal("forged TX should be rejected")
+	}
+
+	if len(n.getPendingTXsAsArray()) != 1 {
+		t.Fatal("rejected TXs should not be pending")
+	}
+}
+
+func TestTxSubmitHandler_Concurrent(t *testing.T) {
+	n, privKey, sender := newTestNode(t)
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	var wg sync.WaitGroup
+	accepted := make(chan bool, 20)
+
+	for i := 0; i < 20; i++ {
+		// Every TX takes the same nonce, only one of them can be admitted
+		signedTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, uint(i+1), 1, ""), testChainID, privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+
+			res := submitTestTx(t, n, TxSubmitReq{Tx: &signedTx})
+			accepted <- res.Code == http.StatusOK
+		}()
+		go func() {
+			defer wg.Done()
+
+			res := httptest.NewRecorder()
+			statusHandler(res, httptest.NewRequest(http.MethodGet, "/node/status", nil), n)
+			listBalancesHandler(res, httptest.NewRequest(http.MethodGet, "/balances/list", nil), n.state)
+		}()
+	}
+
+	wg.Wait()
+	close(accepted)
+
+	acceptedCount := 0
+	for ok := range accepted {
+		if ok {
+			acceptedCount++
+		}
+	}
+
+	if acceptedCount != 1 || len(n.getPendingTXsAsArray()) != 1 {
+		t.Fatalf("exactly one TX should be admitted, got %d", acceptedCount)
+	}
+}
+
+func submitTestTx(t *testing.T, n *Node, req TxSubmitReq) *httptest.ResponseRecorder {
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	txSubmitHandler(res, httptest.NewRequest(http.MethodPost, "/tx/submit", bytes.NewReader(reqJson)), n)
+
+	return res
+}
+
+// newTestNode creates a node with a loaded state, without running it.
+//
+// The returned account owns 1000 TBB in the genesis.
+func newTestNode(t *testing.T) (*Node, *ecdsa.PrivateKey, common.Address) {
+	privKey, _, account, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newTestNodeFor(t, account), privKey, account
+}
+
+// newTestNodeFor creates a node with a loaded state in which the given accounts own 1000 TBB each, without running it.
+//
+// The node mines for the first account.
+func newTestNodeFor(t *testing.T, account common.Address, others ...common.Address) *Node {
+	dataDir, err := getTestDataDirPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { internal.RemoveDir(dataDir) })
+
+	balances := map[common.Address]uint{account: 1000}
+	for _, other := range others {
+		balances[other] = 1000
+	}
+
+	genesisJson, err := json.Marshal(internal.Genesis{
+		Time:      time.Unix(0, 0).UTC(),
+		ChainID:   testChainID,
+		Balances:  balances,
+		Consensus: internal.ConsensusParams{Difficulty: testDifficulty},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = internal.InitDataDir(dataDir, genesisJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := New(dataDir, "127.0.0.1", 8085, account, PeerNode{})
+
+	n.state, err = internal.NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.state.Close)
+
+	return n
+}
@@ -0,0 +1,253 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rawdaGastan/learn_block_chain/jsonrpc"
+)
+
+// rpcMethod exposes a REST route handler over JSON-RPC.
+//
+// The params, named or positional in the given order, become the query of a GET request,
+// or the JSON body of a POST request.
+type rpcMethod struct {
+	handler func(w http.ResponseWriter, r *http.Request, node *Node)
+	params  []string
+	post    bool
+}
+
+var rpcMethods = map[string]rpcMethod{
+	"tbb_getBalances": {handler: func(w http.ResponseWriter, r *http.Request, node *Node) {
+		listBalancesHandler(w, r, node.state)
+	}},
+	"tbb_getNonce":         {handler: nonceHandler, params: []string{"account"}},
+	"tbb_getBlockByHash":   {handler: blockHandler, params: []string{"hash"}},
+	"tbb_getBlockByNumber": {handler: blockHandler, params: []string{"number"}},
+	"tbb_getLatestHeaders": {handler: latestHeadersHandler, params: []string{"count"}},
+	"tbb_getTx":            {handler: txHandler, params: []string{"hash"}},
+	"tbb_getTxProof":       {handler: txProofHandler, params: []string{"hash"}},
+	"tbb_getAccountTxs":    {handler: accountTxsHandler, params: []string{"account", "offset", "limit"}},
+	"tbb_submitTx":         {handler: txSubmitHandler, params: []string{"tx", "raw"}, post: true},
+	"tbb_status":           {handler: statusHandler},
+	"tbb_peers":            {handler: listPeersHandler},
+	"tbb_addPeer":          {handler: addPeerHandler, params: []string{"ip", "port", "miner"}},
+	"tbb_getWork":          {handler: workHandler, params: []string{"miner"}},
+	"tbb_submitWork":       {handler: submitWorkHandler, params: []string{"id", "nonce", "extra_nonce", "time"}, post: true},
+}
+
+// rpcHandler serves JSON-RPC 2.0 calls, a single one or a batch of them.
+func rpcHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeRes(w, jsonrpc.NewErrorResponse(nil, jsonrpc.ParseErrorCode, err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	body = bytes.TrimSpace(body)
+
+	if len(body) == 0 || body[0] != '[' {
+		var req jsonrpc.Request
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			writeRes(w, jsonrpc.NewErrorResponse(nil, jsonrpc.ParseErrorCode, err.Error()))
+			return
+		}
+
+		res, ok := callRPC(req, node)
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		writeRes(w, res)
+		return
+	}
+
+	var batch []json.RawMessage
+	err = json.Unmarshal(body, &batch)
+	if err != nil {
+		writeRes(w, jsonrpc.NewErrorResponse(nil, jsonrpc.ParseErrorCode, err.Error()))
+		return
+	}
+
+	if len(batch) == 0 {
+		writeRes(w, jsonrpc.NewErrorResponse(nil, jsonrpc.InvalidRequestCode, "empty batch"))
+		return
+	}
+
+	responses := make([]jsonrpc.Response, 0, len(batch))
+	for _, rawReq := range batch {
+		var req jsonrpc.Request
+		err = json.Unmarshal(rawReq, &req)
+		if err != nil {
+			responses = append(responses, jsonrpc.NewErrorResponse(nil, jsonrpc.InvalidRequestCode, err.Error()))
+			continue
+		}
+
+		res, ok := callRPC(req, node)
+		if ok {
+			responses = append(responses, res)
+		}
+	}
+
+	// Only notifications, nothing to answer
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeRes(w, responses)
+}
+
+// callRPC runs the call through its REST route handler, it returns false for notifications.
+func callRPC(req jsonrpc.Request, node *Node) (jsonrpc.Response, bool) {
+	res := invokeRPC(req, node)
+	if req.IsNotification() {
+		return jsonrpc.Response{}, false
+	}
+
+	res.ID = req.ID
+
+	return res, true
+}
+
+func invokeRPC(req jsonrpc.Request, node *Node) jsonrpc.Response {
+	if req.Version != jsonrpc.Version || req.Method == "" {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.InvalidRequestCode, "invalid JSON-RPC 2.0 request")
+	}
+
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.MethodNotFoundCode, fmt.Sprintf("method '%s' not found", req.Method))
+	}
+
+	params, err := namedRPCParams(req.Params, method.params)
+	if err != nil {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.InvalidParamsCode, err.Error())
+	}
+
+	httpReq, err := method.newRequest(params)
+	if err != nil {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.InvalidParamsCode, err.Error())
+	}
+
+	rec := newRPCResponseWriter()
+	method.handler(rec, httpReq, node)
+
+	if rec.status != http.StatusOK {
+		errRes := ErrRes{}
+		err = json.Unmarshal(rec.body.Bytes(), &errRes)
+		if err != nil || errRes.Error == "" {
+			errRes.Error = fmt.Sprintf("unexpected response status %d", rec.status)
+		}
+
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.ServerErrorCode, errRes.Error)
+	}
+
+	return jsonrpc.Response{Version: jsonrpc.Version, Result: rec.body.Bytes()}
+}
+
+// namedRPCParams names positional params after the method params.
+func namedRPCParams(rawParams json.RawMessage, names []string) (map[string]json.RawMessage, error) {
+	params := make(map[string]json.RawMessage)
+
+	rawParams = bytes.TrimSpace(rawParams)
+	if len(rawParams) == 0 || string(rawParams) == "null" {
+		return params, nil
+	}
+
+	if rawParams[0] == '[' {
+		var positional []json.RawMessage
+		err := json.Unmarshal(rawParams, &positional)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(positional) > len(names) {
+			return nil, fmt.Errorf("expected at most %d params, got %d", len(names), len(positional))
+		}
+
+		for i, value := range positional {
+			params[names[i]] = value
+		}
+
+		return params, nil
+	}
+
+	err := json.Unmarshal(rawParams, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range params {
+		if !containsString(names, name) {
+			return nil, fmt.Errorf("unknown param '%s'", name)
+		}
+	}
+
+	return params, nil
+}
+
+func (m rpcMethod) newRequest(params map[string]json.RawMessage) (*http.Request, error) {
+	if m.post {
+		body, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+
+		return http.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	}
+
+	query := url.Values{}
+	for name, value := range params {
+		// Strings are passed unquoted, numbers and booleans as they are
+		var text string
+		if json.Unmarshal(value, &text) != nil {
+			text = strings.TrimSpace(string(value))
+		}
+
+		query.Set(name, text)
+	}
+
+	return http.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rpcResponseWriter records what a REST route handler writes.
+type rpcResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRPCResponseWriter() *rpcResponseWriter {
+	return &rpcResponseWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *rpcResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *rpcResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *rpcResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
@@ -0,0 +1,89 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rawdaGastan/learn_block_chain/internal"
+	"github.com/rawdaGastan/learn_block_chain/jsonrpc"
+	"github.com/rawdaGastan/learn_block_chain/wallet"
+)
+
+func TestRPCHandler(t *testing.T) {
+	n, privKey, sender := newTestNode(t)
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rpcHandler(w, r, n)
+	}))
+	defer server.Close()
+
+	client := jsonrpc.NewClientWithHTTP(server.URL, server.Client())
+	ctx := context.Background()
+
+	nonceRes := NonceRes{}
+	err := client.Call(ctx, "tbb_getNonce", []string{sender.Hex()}, &nonceRes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if nonceRes.Nonce != 1 || nonceRes.ChainID != testChainID {
+		t.Fatal("positional params should reach the nonce route")
+	}
+
+	signedTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 10, 1, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayedTx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 20, 1, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submitRes := TxSubmitRes{}
+	statusRes := StatusRes{}
+	batch := []jsonrpc.BatchElem{
+		{Method: "tbb_submitTx", Params: TxSubmitReq{Tx: &signedTx}, Result: &submitRes},
+		// Same nonce as the TX just submitted
+		{Method: "tbb_submitTx", Params: TxSubmitReq{Tx: &replayedTx}},
+		{Method: "tbb_status", Result: &statusRes},
+		{Method: "tbb_unknown"},
+	}
+
+	err = client.BatchCall(ctx, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if batch[0].Error != nil || !submitRes.Success {
+		t.Fatalf("signed TX should be accepted: %v", batch[0].Error)
+	}
+
+	var rpcErr *jsonrpc.Error
+	if !errors.As(batch[1].Error, &rpcErr) || rpcErr.Code != jsonrpc.ServerErrorCode {
+		t.Fatalf("replayed TX should be rejected by the node: %v", batch[1].Error)
+	}
+
+	if batch[2].Error != nil || len(statusRes.PendingTXs) != 1 {
+		t.Fatal("status should list the TX submitted earlier in the batch")
+	}
+
+	if !errors.As(batch[3].Error, &rpcErr) || rpcErr.Code != jsonrpc.MethodNotFoundCode {
+		t.Fatalf("unknown method should be reported: %v", batch[3].Error)
+	}
+
+	res, err := http.Post(server.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"tbb_status"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatal("notifications should not be answered")
+	}
+}
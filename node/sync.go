@@ -2,52 +2,74 @@ package node
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/rawdaGastan/learn_block_chain/client"
 	"github.com/rawdaGastan/learn_block_chain/internal"
 )
 
+// Bounds of the headers-first sync: the headers are fetched and validated by pages,
+// then their blocks are downloaded by batches from up to MaxParallelDownloads peers at once.
+const MaxSyncHeaders = 500
+const MaxSyncBlocks = 50
+const MaxQueuedHeaders = 2000
+const MaxParallelDownloads = 4
+
+// MaxLocatorHashes bounds the block locators, see internal.State.BlockLocator
+const MaxLocatorHashes = 64
+
 func (n *Node) sync(ctx context.Context) error {
 	ticker := time.NewTicker(10 * time.Second)
 	for {
 		select {
 		case <-ticker.C:
-			n.doSync()
+			n.doSync(ctx)
 		case <-ctx.Done():
 			ticker.Stop()
 		}
 	}
 }
 
-func (n *Node) doSync() {
-	for _, peer := range n.knownPeers {
+func (n *Node) doSync(ctx context.Context) {
+	for _, peer := range n.getKnownPeers() {
 		if n.info.IP == peer.IP && n.info.Port == peer.Port {
 			continue
 		}
 
+		// Banned or backed off after failing to answer
+		if !n.isPeerReady(peer) {
+			continue
+		}
+
 		fmt.Printf("Searching for new Peers and their Blocks and Peers: '%s'\n", peer.TcpAddress())
 
-		status, err := queryPeerStatus(peer)
+		status, err := peerClient(peer).Status(ctx)
 		if err != nil {
 			fmt.Printf("ERROR: %s\n", err)
-			fmt.Printf("Peer '%s' was removed from KnownPeers\n", peer.TcpAddress())
-
-			n.RemovePeer(peer)
+			n.peerFailed(peer, err)
 
 			continue
 		}
 
-		err = n.joinKnownPeers(peer)
+		n.peerSucceeded(peer)
+
+		err = n.joinKnownPeers(ctx, peer)
 		if err != nil {
 			fmt.Printf("ERROR: %s\n", err)
+			n.peerFailed(peer, err)
+
 			continue
 		}
 
-		err = n.syncBlocks(peer, status)
+		err = n.syncBlocks(ctx, peer, status)
 		if err != nil {
 			fmt.Printf("ERROR: %s\n", err)
+			n.peerFailed(peer, err)
+
 			continue
 		}
 
@@ -56,132 +78,329 @@ func (n *Node) doSync() {
 			fmt.Printf("ERROR: %s\n", err)
 			continue
 		}
+
+		n.syncPendingTXs(peer, status)
 	}
+
+	n.persistPeers()
 }
 
-func (n *Node) syncBlocks(peer PeerNode, status StatusRes) error {
-	localBlockNumber := n.state.LatestBlock().Header.Number
+// syncPendingTXs imports the pending TXs of the peer missed by the TXs gossip, e.g. while this node was offline.
+func (n *Node) syncPendingTXs(peer PeerNode, status StatusRes) {
+	for _, tx := range status.PendingTXs {
+		err := n.AddValidPendingTX(tx, peer)
+		if err != nil {
+			txHash, _ := tx.Hash()
+			fmt.Printf("ERROR: rejected pending TX '%s' from Peer '%s'. %s\n", txHash.Hex(), peer.TcpAddress(), err)
+		}
+	}
+}
 
+func (n *Node) syncBlocks(ctx context.Context, peer PeerNode, status StatusRes) error {
 	// If the peer has no blocks, ignore it
 	if status.Hash.IsEmpty() {
 		return nil
 	}
 
-	// If the peer has less blocks than us, ignore it
-	if status.Number < localBlockNumber {
+	// If the peer chain is not heavier than ours, ignore it even if it's longer, the fork choice wouldn't adopt it.
+	// This also spares searching the common ancestor of a fork of the same weight on every sync
+	if status.TotalDifficulty <= n.state.TotalDifficulty() {
 		return nil
 	}
 
-	// If it's the genesis block and we already synced it, ignore it
-	if status.Number == 0 && !n.state.LatestBlockHash().IsEmpty() {
-		return nil
+	fmt.Printf("Found a chain of %d blocks and total difficulty %d from Peer %s\n", status.Number+1, status.TotalDifficulty, peer.TcpAddress())
+
+	fmt.Printf("Importing blocks from Peer %s...\n", peer.TcpAddress())
+
+	for {
+		err := n.syncHeaders(ctx, peer)
+		if err != nil {
+			return err
+		}
+
+		if len(n.syncQueue) == 0 {
+			return nil
+		}
+
+		err = n.syncQueuedBlocks(ctx, peer)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// syncHeaders queues the validated headers of the peer chain following the queued headers, or the last block
+// synced from the peer chain, or the local tip, until MaxQueuedHeaders are queued or the peer has no more.
+//
+// When the peer chain doesn't contain the local tip, the headers follow the common ancestor of both chains,
+// the fork choice of the state then decides which branch to keep. Until the peer branch outweighs the local one
+// its blocks are added to a side branch, the following headers are requested after the last of them.
+func (n *Node) syncHeaders(ctx context.Context, peer PeerNode) error {
+	fromBlock := n.state.LatestBlockHash()
+	if !n.syncedBlock.IsEmpty() {
+		fromBlock = n.syncedBlock
 	}
+	if len(n.syncQueue) > 0 {
+		fromBlock = n.syncQueue[len(n.syncQueue)-1].Key
+	}
+
+	searchedAncestor := false
+
+	for len(n.syncQueue) < n.syncQueueSize {
+		headersRes, err := peerClient(peer).Headers(ctx, fromBlock, n.syncHeadersPage)
+
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && (len(n.syncQueue) > 0 || !n.syncedBlock.IsEmpty()) {
+			// The queued headers are not part of the peer chain anymore, e.g. it switched branch since
+			fmt.Printf("Dropping %d queued headers unknown to Peer %s\n", len(n.syncQueue), peer.TcpAddress())
+			n.syncQueue = nil
+			n.syncedBlock = internal.Hash{}
+			fromBlock = n.state.LatestBlockHash()
+
+			continue
+		}
+
+		if errors.As(err, &apiErr) && !searchedAncestor {
+			// The local tip is not part of the peer chain, e.g. this node was partitioned on a fork
+			fromBlock, err = n.findCommonAncestor(ctx, peer)
+			if err != nil {
+				return err
+			}
+
+			searchedAncestor = true
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
 
-	// Display found 1 new block if we sync the genesis block 0
-	newBlocksCount := status.Number - localBlockNumber
-	if localBlockNumber == 0 && status.Number == 0 {
-		newBlocksCount = 1
+		if len(headersRes.Headers) == 0 {
+			return nil
+		}
+
+		err = n.state.ValidateHeaders(n.syncQueue, headersRes.Headers)
+		if err != nil {
+			return n.penalizePeer(peer, ScoreInvalidBlock, fmt.Errorf("invalid headers from Peer '%s'. %s", peer.TcpAddress(), err))
+		}
+
+		n.syncQueue = append(n.syncQueue, headersRes.Headers...)
+		fromBlock = n.syncQueue[len(n.syncQueue)-1].Key
+
+		if len(headersRes.Headers) < n.syncHeadersPage {
+			return nil
+		}
 	}
-	fmt.Printf("Found %d new blocks from Peer %s\n", newBlocksCount, peer.TcpAddress())
 
-	blocks, err := fetchBlocksFromPeer(peer, n.state.LatestBlockHash())
+	return nil
+}
+
+// findCommonAncestor asks the peer for the latest block of the local canonical chain also part of its own.
+func (n *Node) findCommonAncestor(ctx context.Context, peer PeerNode) (internal.Hash, error) {
+	locator := n.state.BlockLocator()
+	if len(locator) > MaxLocatorHashes {
+		// The first block is kept, any peer of the same network has it
+		locator = append(locator[:MaxLocatorHashes-1], locator[len(locator)-1])
+	}
+
+	ancestor, err := peerClient(peer).CommonAncestor(ctx, locator)
 	if err != nil {
-		return err
+		return internal.Hash{}, fmt.Errorf("no common ancestor with Peer '%s'. %s", peer.TcpAddress(), err)
 	}
 
-	return n.state.AddBlocks(blocks)
+	fmt.Printf("Found common ancestor '%s' at height %d with Peer %s\n", ancestor.Hash.Hex(), ancestor.Number, peer.TcpAddress())
+
+	return ancestor.Hash, nil
 }
 
-func (n *Node) syncKnownPeers(peer PeerNode, status StatusRes) error {
-	for _, statusPeer := range status.KnownPeers {
-		if !n.IsKnownPeer(statusPeer) {
-			fmt.Printf("Found new Peer %s\n", statusPeer.TcpAddress())
+// syncQueuedBlocks downloads and adds the blocks of the queued headers, in batches spread over the connected peers.
+//
+// Only the headers of the blocks not added yet stay queued, a following sync resumes from them.
+func (n *Node) syncQueuedBlocks(ctx context.Context, peer PeerNode) error {
+	peers := n.downloadPeers(peer)
+
+	for len(n.syncQueue) > 0 {
+		headers := n.syncQueue
+		if len(headers) > n.syncBlocksBatch*MaxParallelDownloads {
+			headers = headers[:n.syncBlocksBatch*MaxParallelDownloads]
+		}
+
+		// The blocks are downloaded in order up to the first batch no peer could serve
+		blocks, downloadErr := downloadBlocks(ctx, peers, headers, n.syncBlocksBatch)
+
+		for _, b := range blocks {
+			_, err := n.state.AddBlock(b)
+			if err != nil {
+				// The headers came from the same peer as the block, none of them can be trusted
+				n.syncQueue = nil
+
+				return n.penalizePeer(peer, ScoreInvalidBlock, fmt.Errorf("invalid block from Peer '%s'. %s", peer.TcpAddress(), err))
+			}
 
-			n.AddPeer(statusPeer)
+			n.syncedBlock = n.syncQueue[0].Key
+			n.syncQueue = n.syncQueue[1:]
+		}
+
+		if downloadErr != nil {
+			return downloadErr
 		}
 	}
 
 	return nil
 }
 
-func (n *Node) joinKnownPeers(peer PeerNode) error {
-	if peer.connected {
-		return nil
+// downloadPeers returns the peer being synced with, followed by other connected peers to download blocks from.
+func (n *Node) downloadPeers(peer PeerNode) []PeerNode {
+	peers := []PeerNode{peer}
+
+	for _, knownPeer := range n.getKnownPeers() {
+		if len(peers) == MaxParallelDownloads {
+			break
+		}
+
+		isSelf := knownPeer.IP == n.info.IP && knownPeer.Port == n.info.Port
+		if isSelf || !knownPeer.Connected || knownPeer.TcpAddress() == peer.TcpAddress() {
+			continue
+		}
+
+		peers = append(peers, knownPeer)
 	}
 
-	url := fmt.Sprintf(
-		"http://%s%s?%s=%s&%s=%d",
-		peer.TcpAddress(),
-		"/node/peer",
-		"ip",
-		n.info.IP,
-		"port",
-		n.info.Port,
-	)
+	return peers
+}
 
-	res, err := http.Get(url)
-	if err != nil {
-		return err
+// downloadBlocks fetches the blocks of the headers, a batch per goroutine.
+//
+// Each batch starts with a different peer and moves on to the next one on failure.
+// The blocks of the batches downloaded before the first failed one are returned with its error.
+func downloadBlocks(ctx context.Context, peers []PeerNode, headers []internal.BlockHeaderFS, batchSize int) ([]internal.Block, error) {
+	batchesCount := (len(headers) + batchSize - 1) / batchSize
+	batches := make([][]internal.Block, batchesCount)
+	errs := make([]error, batchesCount)
+
+	var wg sync.WaitGroup
+	for i := 0; i < batchesCount; i++ {
+		batchHeaders := headers[i*batchSize:]
+		if len(batchHeaders) > batchSize {
+			batchHeaders = batchHeaders[:batchSize]
+		}
+
+		wg.Add(1)
+		go func(i int, batchHeaders []internal.BlockHeaderFS) {
+			defer wg.Done()
+
+			for attempt := 0; attempt < len(peers); attempt++ {
+				peer := peers[(i+attempt)%len(peers)]
+
+				batches[i], errs[i] = downloadBatch(ctx, peer, batchHeaders)
+				if errs[i] == nil {
+					return
+				}
+			}
+		}(i, batchHeaders)
 	}
+	wg.Wait()
 
-	addPeerRes := AddPeerRes{}
-	err = readRes(res, &addPeerRes)
-	if err != nil {
-		return err
+	blocks := make([]internal.Block, 0, len(headers))
+	for i := range batches {
+		if errs[i] != nil {
+			return blocks, errs[i]
+		}
+
+		blocks = append(blocks, batches[i]...)
+	}
+
+	return blocks, nil
+}
+
+// downloadBatch fetches the blocks of the headers from the peer, checking each block matches its header.
+func downloadBatch(ctx context.Context, peer PeerNode, headers []internal.BlockHeaderFS) ([]internal.Block, error) {
+	hashes := make([]internal.Hash, len(headers))
+	for i, header := range headers {
+		hashes[i] = header.Key
 	}
-	if addPeerRes.Error != "" {
-		return fmt.Errorf(addPeerRes.Error)
+
+	blocks, err := peerClient(peer).Blocks(ctx, hashes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download blocks from Peer '%s'. %s", peer.TcpAddress(), err)
 	}
 
-	knownPeer := n.knownPeers[peer.TcpAddress()]
-	knownPeer.connected = addPeerRes.Success
+	if len(blocks) != len(hashes) {
+		return nil, fmt.Errorf("%w. Peer '%s' returned %d blocks instead of %d", client.ErrMalformedResponse, peer.TcpAddress(), len(blocks), len(hashes))
+	}
 
-	n.AddPeer(knownPeer)
+	for i, b := range blocks {
+		blockHash, err := b.Hash()
+		if err != nil {
+			return nil, err
+		}
 
-	if !addPeerRes.Success {
-		return fmt.Errorf("unable to join KnownPeers of '%s'", peer.TcpAddress())
+		if blockHash != hashes[i] {
+			return nil, fmt.Errorf("%w. Peer '%s' returned block '%s' instead of '%s'", client.ErrMalformedResponse, peer.TcpAddress(), blockHash.Hex(), hashes[i].Hex())
+		}
 	}
 
-	return nil
+	return blocks, nil
 }
 
-func queryPeerStatus(peer PeerNode) (StatusRes, error) {
-	url := fmt.Sprintf("http://%s%s", peer.TcpAddress(), "/node/status")
-	res, err := http.Get(url)
-	if err != nil {
-		return StatusRes{}, err
-	}
+func (n *Node) syncKnownPeers(peer PeerNode, status StatusRes) error {
+	for _, statusPeer := range status.KnownPeers {
+		if !n.IsKnownPeer(statusPeer) {
+			err := n.AddPeer(statusPeer)
+			if err != nil {
+				fmt.Printf("ERROR: %s\n", err)
+				continue
+			}
 
-	statusRes := StatusRes{}
-	err = readRes(res, &statusRes)
-	if err != nil {
-		return StatusRes{}, err
+			fmt.Printf("Found new Peer %s\n", statusPeer.TcpAddress())
+		}
 	}
 
-	return statusRes, nil
+	return nil
 }
 
-func fetchBlocksFromPeer(peer PeerNode, fromBlock internal.Hash) ([]internal.Block, error) {
-	fmt.Printf("Importing blocks from Peer %s...\n", peer.TcpAddress())
+// joinKnownPeers handshakes with the peer, once per connection, and records the info it negotiated.
+//
+// A peer of another network, whether this node or the peer finds it out, is banned.
+// A peer refusing the handshake for another reason, e.g. its known peers are full, is retried later.
+func (n *Node) joinKnownPeers(ctx context.Context, peer PeerNode) error {
+	if peer.Connected {
+		return nil
+	}
 
-	url := fmt.Sprintf(
-		"http://%s%s?%s=%s",
-		peer.TcpAddress(),
-		"/node/sync",
-		"fromBlock",
-		fromBlock.Hex(),
-	)
+	res, err := peerClient(peer).Handshake(ctx, HandshakeReq{Peer: n.info, Info: n.peerInfo()})
+
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden {
+		return n.banPeer(peer, fmt.Errorf("Peer '%s' of another network refused the handshake. %s", peer.TcpAddress(), err))
+	}
+
+	if errors.As(err, &apiErr) {
+		return n.peerRefused(peer, fmt.Errorf("Peer '%s' refused the handshake. %w", peer.TcpAddress(), err))
+	}
 
-	res, err := http.Get(url)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	syncRes := SyncRes{}
-	err = readRes(res, &syncRes)
+	err = n.checkPeerInfo(res.Info)
 	if err != nil {
-		return nil, err
+		return n.banPeer(peer, fmt.Errorf("incompatible Peer '%s'. %s", peer.TcpAddress(), err))
 	}
 
-	return syncRes.Blocks, nil
+	knownPeer, isKnownPeer := n.getKnownPeers()[peer.TcpAddress()]
+	if !isKnownPeer {
+		knownPeer = peer
+	}
+	knownPeer.Connected = true
+	knownPeer.Account = res.Peer.Account
+	knownPeer.Info = &res.Info
+
+	return n.AddPeer(knownPeer)
+}
+
+// peerClient calls the routes of the peer, the same way external programs call the node.
+func peerClient(peer PeerNode) *client.Client {
+	return client.New(peer.TcpAddress())
 }
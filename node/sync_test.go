@@ -0,0 +1,259 @@
+package node
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rawdaGastan/learn_block_chain/internal"
+	"github.com/rawdaGastan/learn_block_chain/wallet"
+)
+
+func TestSyncBlocks_HeadersFirstResumes(t *testing.T) {
+	source, privKey, sender := newTestNode(t)
+	n := newTestNodeFor(t, sender)
+	n.syncHeadersPage = 2
+	n.syncBlocksBatch = 2
+
+	mineTestBlocks(t, source, privKey, sender, 7)
+
+	var failedBlocksReqs int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/node/sync/headers", func(w http.ResponseWriter, r *http.Request) {
+		syncHeadersHandler(w, r, source)
+	})
+	mux.HandleFunc("/node/sync/blocks", func(w http.ResponseWriter, r *http.Request) {
+		// The peer goes away while the last blocks are downloaded
+		block4Hash := source.state.GetLatestHeaders(3)[2].Key
+		for _, hash := range r.URL.Query()["hash"] {
+			if hash == block4Hash.Hex() && atomic.AddInt32(&failedBlocksReqs, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		syncBlocksHandler(w, r, source)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	peer := newTestPeer(t, server, sender)
+	status := StatusRes{Hash: source.state.LatestBlockHash(), Number: source.state.LatestBlock().Header.Number, TotalDifficulty: source.state.TotalDifficulty()}
+
+	err := n.syncBlocks(context.Background(), peer, status)
+	if err == nil {
+		t.Fatal("the sync should fail when the peer goes away")
+	}
+
+	if n.state.LatestBlock().Header.Number != 3 || len(n.syncQueue) != 3 {
+		t.Fatalf("the blocks of the batches before the failed one should be added, got tip %d and %d queued headers", n.state.LatestBlock().Header.Number, len(n.syncQueue))
+	}
+
+	err = n.syncBlocks(context.Background(), peer, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.state.LatestBlockHash() != source.state.LatestBlockHash() || len(n.syncQueue) != 0 {
+		t.Fatal("the sync should resume from the queued headers up to the peer tip")
+	}
+}
+
+func TestSyncBlocks_FromForkPoint(t *testing.T) {
+	source, privKey, sender := newTestNode(t)
+	n := newTestNodeFor(t, sender)
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	mineTestBlocks(t, source, privKey, sender, 2)
+
+	shared, err := source.state.GetBlocksAfter(internal.Hash{}, MaxSyncBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.state.AddBlocks(shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// While partitioned, each node extends its own branch
+	mineTestBlocks(t, source, privKey, sender, 3)
+
+	tx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 7, 3, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forkBlock, err := Mine(context.Background(), NewPendingBlock(n.state.LatestBlockHash(), n.state.NextBlockNumber(), n.state.NextDifficulty(), sender, []internal.SignedTx{tx}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = n.state.AddBlock(forkBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/node/sync/headers", func(w http.ResponseWriter, r *http.Request) {
+		syncHeadersHandler(w, r, source)
+	})
+	mux.HandleFunc("/node/sync/blocks", func(w http.ResponseWriter, r *http.Request) {
+		syncBlocksHandler(w, r, source)
+	})
+	mux.HandleFunc("/node/sync/ancestor", func(w http.ResponseWriter, r *http.Request) {
+		syncAncestorHandler(w, r, source)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	status := StatusRes{Hash: source.state.LatestBlockHash(), Number: source.state.LatestBlock().Header.Number, TotalDifficulty: source.state.TotalDifficulty()}
+
+	err = n.syncBlocks(context.Background(), newTestPeer(t, server, sender), status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.state.LatestBlockHash() != source.state.LatestBlockHash() {
+		t.Fatal("the heavier branch of the peer should replace the local fork")
+	}
+}
+
+func TestSyncBlocks_LongForkOnSideBranch(t *testing.T) {
+	source, privKey, sender := newTestNode(t)
+	n := newTestNodeFor(t, sender)
+	n.syncQueueSize = 2
+	n.syncHeadersPage = 1
+	n.syncBlocksBatch = 1
+
+	mineTestBlocks(t, source, privKey, sender, 2)
+
+	shared, err := source.state.GetBlocksAfter(internal.Hash{}, MaxSyncBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.state.AddBlocks(shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first queued blocks of the peer branch don't outweigh the local fork, they land on a side branch
+	n.info.Account = internal.NewAccount(testKsBabaYagaAccount)
+	mineTestBlocks(t, n, privKey, sender, 3)
+	mineTestBlocks(t, source, privKey, sender, 5)
+
+	var blocksReqs int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/node/sync/headers", func(w http.ResponseWriter, r *http.Request) {
+		syncHeadersHandler(w, r, source)
+	})
+	mux.HandleFunc("/node/sync/blocks", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&blocksReqs, 1) > 5 {
+			t.Error("the blocks already added to the side branch should not be downloaded again")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		syncBlocksHandler(w, r, source)
+	})
+	mux.HandleFunc("/node/sync/ancestor", func(w http.ResponseWriter, r *http.Request) {
+		syncAncestorHandler(w, r, source)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	status := StatusRes{Hash: source.state.LatestBlockHash(), Number: source.state.LatestBlock().Header.Number, TotalDifficulty: source.state.TotalDifficulty()}
+
+	err = n.syncBlocks(context.Background(), newTestPeer(t, server, sender), status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n.state.LatestBlockHash() != source.state.LatestBlockHash() {
+		t.Fatal("the sync should continue on the side branch until it outweighs the local fork")
+	}
+}
+
+func TestSyncBlocks_IgnoresChainNotHeavier(t *testing.T) {
+	n, privKey, sender := newTestNode(t)
+
+	mineTestBlocks(t, n, privKey, sender, 2)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("nothing should be requested from a chain not heavier than the local one, got '%s'", r.URL.Path)
+	}))
+	defer server.Close()
+
+	peer := newTestPeer(t, server, sender)
+
+	// Longer but lighter, the fork choice would keep the local chain anyway
+	status := StatusRes{Hash: internal.Hash{0x01}, Number: 10, TotalDifficulty: n.state.TotalDifficulty() - 1}
+
+	err := n.syncBlocks(context.Background(), peer, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A fork of the same weight, the first seen branch is kept
+	status = StatusRes{Hash: internal.Hash{0x01}, Number: n.state.LatestBlock().Header.Number, TotalDifficulty: n.state.TotalDifficulty()}
+
+	err = n.syncBlocks(context.Background(), peer, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mineTestBlocks adds count blocks of a single TX from the sender to the node canonical chain.
+func mineTestBlocks(t *testing.T, n *Node, privKey *ecdsa.PrivateKey, sender common.Address, count int) {
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+
+	for i := 0; i < count; i++ {
+		nonce := n.state.GetNextAccountNonce(sender)
+		tx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 1, nonce, ""), testChainID, privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pendingBlock := NewPendingBlock(n.state.LatestBlockHash(), n.state.NextBlockNumber(), n.state.NextDifficulty(), n.info.Account, []internal.SignedTx{tx})
+		minedBlock, err := Mine(context.Background(), pendingBlock)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = n.state.AddBlock(minedBlock)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestPeer returns the peer served by the test server.
+func newTestPeer(t *testing.T, server *httptest.Server, account common.Address) PeerNode {
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, err := net.SplitHostPort(serverUrl.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerPort, err := strconv.ParseUint(port, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewPeerNode(host, peerPort, false, true, account)
+}
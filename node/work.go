@@ -0,0 +1,179 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rawdaGastan/learn_block_chain/internal"
+)
+
+// MaxWorkTemplates bounds the block templates kept for the external miners, the oldest ones are dropped first
+const MaxWorkTemplates = 16
+
+// MaxWorkTimeDrift is how far in the future, in seconds, an external miner can roll the block time, as far as the peers accept
+const MaxWorkTimeDrift = internal.MaxBlockTimeDrift
+
+// workHandler hands out a template of the next block for an external miner to solve.
+//
+// The block pays the 'miner' account, the node account by default.
+func workHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	miner := node.info.Account
+	if r.URL.Query().Has("miner") {
+		miner = internal.NewAccount(r.URL.Query().Get("miner"))
+	}
+
+	work, err := node.newWork(miner)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, work)
+}
+
+func submitWorkHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	req := WorkSubmitReq{}
+	err := readReq(r, &req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	res, err := node.submitWork(req)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, res)
+}
+
+// MineWork solves a block template handed out by a node, the solution is submitted back with client.SubmitWork.
+func MineWork(ctx context.Context, work WorkRes, workers int) (WorkSubmitReq, MiningStats, error) {
+	pb := PendingBlock{
+		parent:     work.Header.Parent,
+		number:     work.Header.Number,
+		time:       work.Header.Time,
+		difficulty: work.Header.Difficulty,
+		miner:      work.Header.Miner,
+		txs:        work.TXs,
+	}
+
+	block, stats, err := MineWithWorkers(ctx, pb, workers)
+	if err != nil {
+		return WorkSubmitReq{}, stats, err
+	}
+
+	return WorkSubmitReq{
+		ID:         work.ID,
+		Nonce:      block.Header.Nonce,
+		ExtraNonce: block.Header.ExtraNonce,
+		Time:       block.Header.Time,
+	}, stats, nil
+}
+
+// newWork creates a template of a block of the pending TXs, identified by its hash before mining.
+func (n *Node) newWork(miner common.Address) (WorkRes, error) {
+	pb, ok := n.newPendingBlock(miner)
+	if !ok {
+		return WorkRes{}, fmt.Errorf("no pending TXs to mine")
+	}
+
+	template := internal.NewBlock(pb.parent, pb.time, pb.number, 0, pb.difficulty, pb.miner, pb.txs)
+
+	workID, err := template.Hash()
+	if err != nil {
+		return WorkRes{}, err
+	}
+
+	n.addWork(workID, template)
+
+	target := internal.Hash{}
+	internal.DifficultyToTarget(pb.difficulty).FillBytes(target[:])
+
+	return WorkRes{ID: workID, Header: template.Header, TXs: template.TXs, Target: target}, nil
+}
+
+// submitWork adds the block of a solved template to the chain.
+//
+// A block becoming the tip stops the node own mining and is announced to the peers.
+func (n *Node) submitWork(req WorkSubmitReq) (WorkSubmitRes, error) {
+	block, ok := n.getWork(req.ID)
+	if !ok {
+		return WorkSubmitRes{}, fmt.Errorf("work '%s' is unknown or expired", req.ID.Hex())
+	}
+
+	block.Header.Nonce = req.Nonce
+	block.Header.ExtraNonce = req.ExtraNonce
+
+	if req.Time != 0 {
+		if req.Time < block.Header.Time || req.Time > uint64(time.Now().Unix())+MaxWorkTimeDrift {
+			return WorkSubmitRes{}, fmt.Errorf("block time '%d' is out of the work time range", req.Time)
+		}
+
+		block.Header.Time = req.Time
+	}
+
+	blockHash, err := block.Hash()
+	if err != nil {
+		return WorkSubmitRes{}, err
+	}
+
+	if !internal.IsBlockHashValid(blockHash, block.Header.Difficulty) {
+		return WorkSubmitRes{}, fmt.Errorf("block hash '%s' doesn't meet the difficulty '%d'", blockHash.Hex(), block.Header.Difficulty)
+	}
+
+	if n.state.HasBlock(blockHash) {
+		return WorkSubmitRes{}, fmt.Errorf("block '%s' was already submitted", blockHash.Hex())
+	}
+
+	_, err = n.state.AddBlock(block)
+	if err != nil {
+		return WorkSubmitRes{}, err
+	}
+
+	if n.state.LatestBlockHash() != blockHash {
+		return WorkSubmitRes{Hash: blockHash, Canonical: false}, nil
+	}
+
+	fmt.Printf("Added Block '%s' mined by '%s' from work '%s'\n", blockHash.Hex(), block.Header.Miner.Hex(), req.ID.Hex())
+	n.removeMinedPendingTXs(block)
+
+	go func() {
+		// Stop mining a block competing with the submitted one
+		n.newSyncedBlocks <- block
+	}()
+
+	go n.announceBlock(block, n.info)
+
+	return WorkSubmitRes{Hash: blockHash, Canonical: true}, nil
+}
+
+func (n *Node) addWork(workID internal.Hash, template internal.Block) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, exists := n.works[workID]; exists {
+		return
+	}
+
+	n.works[workID] = template
+	n.workIDs = append(n.workIDs, workID)
+
+	if len(n.workIDs) > MaxWorkTemplates {
+		delete(n.works, n.workIDs[0])
+		n.workIDs = n.workIDs[1:]
+	}
+}
+
+func (n *Node) getWork(workID internal.Hash) (internal.Block, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	template, ok := n.works[workID]
+
+	return template, ok
+}
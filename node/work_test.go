@@ -0,0 +1,83 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rawdaGastan/learn_block_chain/internal"
+	"github.com/rawdaGastan/learn_block_chain/wallet"
+)
+
+func TestWorkHandlers_ExternalMiner(t *testing.T) {
+	n, privKey, sender := newTestNode(t)
+	receiver := internal.NewAccount(testKsBabaYagaAccount)
+	externalMiner := internal.NewAccount("0x0000000000000000000000000000000000000042")
+
+	tx, err := wallet.SignTx(internal.NewTx(sender, receiver, internal.TxGas, internal.TxGasPriceDefault, 10, 1, ""), testChainID, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.AddValidPendingTX(tx, n.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work := WorkRes{}
+	getExplorerTestRes(t, n, workHandler, "/mining/work?miner="+externalMiner.Hex(), &work)
+	if work.Header.Miner != externalMiner || len(work.TXs) != 1 {
+		t.Fatal("the work should be a block of the pending TX paying the external miner")
+	}
+
+	solution, _, err := MineWork(context.Background(), work, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsolved := solution
+	unsolved.Nonce++
+	if submitTestWork(t, n, unsolved).Code == http.StatusOK {
+		t.Fatal("a nonce not meeting the difficulty should be rejected")
+	}
+
+	unknown := solution
+	unknown.ID = internal.Hash{1}
+	if submitTestWork(t, n, unknown).Code == http.StatusOK {
+		t.Fatal("a solution of an unknown work should be rejected")
+	}
+
+	res := submitTestWork(t, n, solution)
+	if res.Code != http.StatusOK {
+		t.Fatalf("the solution should be accepted, got %s", res.Body.String())
+	}
+
+	submitRes := WorkSubmitRes{}
+	err = json.Unmarshal(res.Body.Bytes(), &submitRes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !submitRes.Canonical || n.state.LatestBlockHash() != submitRes.Hash {
+		t.Fatal("the mined block should be the new tip")
+	}
+
+	if len(n.getPendingTXsAsArray()) != 0 || n.state.Balances[externalMiner] == 0 {
+		t.Fatal("the mined TX should leave the pool and the external miner should be paid")
+	}
+}
+
+func submitTestWork(t *testing.T, n *Node, req WorkSubmitReq) *httptest.ResponseRecorder {
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	submitWorkHandler(res, httptest.NewRequest(http.MethodPost, "/mining/submit", bytes.NewReader(reqJson)), n)
+
+	return res
+}
@@ -31,7 +31,7 @@ func NewKeystoreAccount(dataDir, password string) (common.Address, error) {
 	return acc.Address, nil
 }
 
-func SignTxWithKeystoreAccount(tx internal.Tx, acc common.Address, pwd, keystoreDir string) (internal.SignedTx, error) {
+func SignTxWithKeystoreAccount(tx internal.Tx, chainID string, acc common.Address, pwd, keystoreDir string) (internal.SignedTx, error) {
 	ks := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
 	ksAccount, err := ks.Find(accounts.Account{Address: acc})
 	if err != nil {
@@ -48,7 +48,7 @@ func SignTxWithKeystoreAccount(tx internal.Tx, acc common.Address, pwd, keystore
 		return internal.SignedTx{}, err
 	}
 
-	signedTx, err := SignTx(tx, key.PrivateKey)
+	signedTx, err := SignTx(tx, chainID, key.PrivateKey)
 	if err != nil {
 		return internal.SignedTx{}, err
 	}
@@ -56,7 +56,13 @@ func SignTxWithKeystoreAccount(tx internal.Tx, acc common.Address, pwd, keystore
 	return signedTx, nil
 }
 
-func SignTx(tx internal.Tx, privKey *ecdsa.PrivateKey) (internal.SignedTx, error) {
+// SignTx signs the TX for the given chain only, the chain ID is part of the signed payload.
+func SignTx(tx internal.Tx, chainID string, privKey *ecdsa.PrivateKey) (internal.SignedTx, error) {
+	if chainID == "" {
+		return internal.SignedTx{}, fmt.Errorf("chain ID is required to sign a TX")
+	}
+	tx.ChainID = chainID
+
 	rawTx, err := tx.Encode()
 	if err != nil {
 		return internal.SignedTx{}, err
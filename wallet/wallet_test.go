@@ -20,6 +20,7 @@ import (
 //	./node/test_andrej--3eb92807f1f91a8d4d85bc908c7f86dcddb1df57
 //	./node/test_babayaga--6fdc0d8d15ae6b4ebf45c52fd2aafbcbb19a65c8
 const testKeystoreAccountsPwd = "security123"
+const testChainID = "tbb-test"
 
 // Prints a PK:
 //
@@ -123,9 +124,9 @@ func TestSignTxWithKeystoreAccount(t *testing.T) {
 		return
 	}
 
-	tx := internal.NewTx(andrej, babaYaga, 100, 1, "")
+	tx := internal.NewTx(andrej, babaYaga, internal.TxGas, internal.TxGasPriceDefault, 100, 1, "")
 
-	signedTx, err := SignTxWithKeystoreAccount(tx, andrej, testKeystoreAccountsPwd, GetKeystoreDirPath(tmpDir))
+	signedTx, err := SignTxWithKeystoreAccount(tx, testChainID, andrej, testKeystoreAccountsPwd, GetKeystoreDirPath(tmpDir))
 	if err != nil {
 		t.Error(err)
 		return
@@ -140,6 +141,20 @@ func TestSignTxWithKeystoreAccount(t *testing.T) {
 	if !ok {
 		t.Fatal("the TX was signed by 'from' account and should have been authentic")
 	}
+
+	// Replayed on another chain
+	replayedTx := signedTx
+	replayedTx.ChainID = "another-chain"
+
+	ok, err = replayedTx.IsAuthentic()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if ok {
+		t.Fatal("the TX was signed for another chain and should have not be authentic")
+	}
 }
 
 func TestSignForgedTxWithKeystoreAccount(t *testing.T) {
@@ -161,9 +176,9 @@ func TestSignForgedTxWithKeystoreAccount(t *testing.T) {
 		return
 	}
 
-	forgedTx := internal.NewTx(babaYaga, hacker, 100, 1, "")
+	forgedTx := internal.NewTx(babaYaga, hacker, internal.TxGas, internal.TxGasPriceDefault, 100, 1, "")
 
-	signedTx, err := SignTxWithKeystoreAccount(forgedTx, hacker, testKeystoreAccountsPwd, GetKeystoreDirPath(tmpDir))
+	signedTx, err := SignTxWithKeystoreAccount(forgedTx, testChainID, hacker, testKeystoreAccountsPwd, GetKeystoreDirPath(tmpDir))
 	if err != nil {
 		t.Error(err)
 		return